@@ -4,10 +4,8 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"net/http"
-	"strings"
+	"path/filepath"
 
 	"github.com/ghchinoy/atmotool/cm"
 	"github.com/ghchinoy/atmotool/control"
@@ -89,10 +87,17 @@ func CreateAPIwithSpec(name string, specpath string, config control.Configuratio
 	// first, upload the spec doc to the CMS
 	specresponse, err := dropbox.AddSpecToDropbox(config, specpath, debug)
 	if err != nil {
-		log.Println(err.Error())
 		return err
 	}
+	if config.DryRun && len(specresponse.ServiceDescriptorDocument) == 0 {
+		// the spec wasn't uploaded, so refer to it by placeholders
+		specresponse.FileName = filepath.Base(specpath)
+		specresponse.ServiceDescriptorDocument = []dropbox.SpecDoc{{ServiceName: []string{"<service in " + specresponse.FileName + ">"}}}
+	}
 	// then, create a request with that info
+	if len(specresponse.ServiceDescriptorDocument) == 0 || len(specresponse.ServiceDescriptorDocument[0].ServiceName) == 0 {
+		return fmt.Errorf("No service found in spec %s", specpath)
+	}
 	specref := ServiceDescriptorReference{
 		ServiceName:  specresponse.ServiceDescriptorDocument[0].ServiceName[0],
 		FileName:     specresponse.FileName,
@@ -108,7 +113,6 @@ func CreateAPIwithSpec(name string, specpath string, config control.Configuratio
 	// finally create the api
 	apiinfo, err = postNewAPI(bytes, config, debug)
 	if err != nil {
-		log.Println(err.Error())
 		return err
 	}
 	printCreatedAPIInfo(apiinfo)
@@ -130,7 +134,6 @@ func CreateAPINameOnly(name string, config control.Configuration, debug bool) er
 	}
 	apiinfo, err := postNewAPI(bytes, config, debug)
 	if err != nil {
-		log.Println(err.Error())
 		return err
 	}
 	printCreatedAPIInfo(apiinfo)
@@ -161,7 +164,6 @@ func CreateAPINameOnlyWithEndpoint(name string, endpoint string, config control.
 	}
 	apiinfo, err := postNewAPI(bytes, config, debug)
 	if err != nil {
-		log.Println(err.Error())
 		return err
 	}
 	printCreatedAPIInfo(apiinfo)
@@ -169,6 +171,10 @@ func CreateAPINameOnlyWithEndpoint(name string, endpoint string, config control.
 }
 
 func printCreatedAPIInfo(api cm.APICreatedResponse) {
+	if api.APIID == "" {
+		// nothing was created, as in a dry run
+		return
+	}
 	var vernamelen int
 	if len(api.APIVersion.Name) < 3 {
 		vernamelen = 3
@@ -194,55 +200,29 @@ func postNewAPI(message []byte, config control.Configuration, debug bool) (cm.AP
 
 	var apiinfo cm.APICreatedResponse
 
-	client, _, err := control.LoginToCM(config, debug)
+	client, err := control.NewClient(config, debug)
 	if err != nil {
-		log.Fatalln(err)
 		return apiinfo, err
 	}
 
-	url := config.URL + CMAddAPIURI
-
-	data := bytes.NewReader(message)
-
-	req, err := http.NewRequest("POST", url, data)
-	req.Header.Add("Content-Type", "application/vnd.soa.v81+json; charset=UTF-8")
-	req.Header.Add("Accept", "application/vnd.soa.v81+json")
-	req.Header.Add("Host", strings.Trim(config.URL, "https://"))
-	req = control.AddCsrfHeader(req, client)
-	if debug {
-		log.Println("curl command:", control.CURLThis(client, req))
-	}
-	if debug {
-		control.DebugRequestHeader(req)
-	}
-	resp, err := client.Do(req)
-
-	defer resp.Body.Close()
-
-	if debug {
-		control.DebugResponseHeader(resp)
-	}
-
-	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	req, err := client.NewRequest("POST", CMAddAPIURI, bytes.NewReader(message))
 	if err != nil {
 		return apiinfo, err
 	}
+	req.Header.Set("Content-Type", "application/vnd.soa.v81+json; charset=UTF-8")
+	req.Header.Set("Accept", "application/vnd.soa.v81+json")
 	if debug {
-		fmt.Printf("%s\n", resp.Status)
-		fmt.Printf("%s\n", bodyBytes)
-	}
-
-	if resp.StatusCode != 200 {
-		fmt.Println(resp.Status)
-	} else {
-		fmt.Println("API Created ok")
+		control.DebugRequestHeader(req)
 	}
 
-	err = json.Unmarshal(bodyBytes, &apiinfo)
+	err = client.Do(req, &apiinfo)
 	if err != nil {
-		fmt.Println("Can't turn response into an API Info struct")
 		return apiinfo, err
 	}
+	if config.DryRun {
+		return apiinfo, nil
+	}
+	fmt.Println("API Created ok")
 
 	return apiinfo, nil
 }
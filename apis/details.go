@@ -4,14 +4,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"net/http"
-
 	"strings"
 
 	"github.com/ghchinoy/atmotool/cm"
 	"github.com/ghchinoy/atmotool/control"
+	"github.com/ghchinoy/atmotool/output"
 )
 
 const (
@@ -29,54 +27,53 @@ const (
 	APISettings = "/api/apis/%s/settings"
 )
 
-// ShowDetailsforAPIID outputs API details
-func ShowDetailsforAPIID(apiID string, useVersion bool, config control.Configuration, debug bool) error {
-	client, _, err := control.LoginToCM(config, debug)
+// ShowDetailsforAPIID outputs API details. apiID is anything ResolveAPIID
+// accepts, or with useVersion, anything ResolveAPIVersionID accepts.
+func ShowDetailsforAPIID(apiID string, useVersion bool, config control.Configuration, format output.Options, debug bool) error {
+	client, err := control.NewClient(config, debug)
 	if err != nil {
-		log.Fatalln(err)
 		return err
 	}
 
 	var pattern string
+	var id string
 	if useVersion {
 		pattern = APIGetVersionInfo
+		id, err = ResolveAPIVersionID(client, apiID, debug)
 	} else {
 		pattern = APIGetInfoIncludeDefault
+		id, err = ResolveAPIID(client, apiID, debug)
 	}
-
-	url := config.URL + fmt.Sprintf(pattern, apiID)
-	req, err := http.NewRequest("GET", url, nil)
-	req.Header.Add("Accept", "application/json")
-	if debug {
-		log.Println("Calling", url)
-		control.DebugRequestHeader(req)
-	}
-	resp, err := client.Do(req)
-	defer resp.Body.Close()
-	bodyBytes, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return err
-	}
-	if debug {
-		control.DebugResponseHeader(resp)
+	if errors.Is(err, errVersionID) {
+		if debug {
+			log.Println(err)
+		}
+		return errors.New("Please provide an API ID. An API ID was expected; instead, an API Version ID was provided.\nPlease use the --ver flag.")
 	}
-	var apiInfo cm.ApisResponse
-	err = json.Unmarshal(bodyBytes, &apiInfo)
 	if err != nil {
 		return err
 	}
-	if resp.StatusCode == 500 {
+
+	var bodyBytes []byte
+	err = client.Get(fmt.Sprintf(pattern, id), &bodyBytes)
+	var fault *cm.FaultError
+	if errors.As(err, &fault) && fault.StatusCode == 500 {
 		var message string
-		if strings.Contains(apiInfo.FaultMessage, "[apiversion]") {
+		if strings.Contains(fault.FaultMessage, "[apiversion]") {
 			message = "Please provide an API ID. An API ID was expected; instead, an API Version ID was provided.\nPlease use the --ver flag."
 		}
-		if strings.Contains(apiInfo.FaultMessage, "[api]") {
+		if strings.Contains(fault.FaultMessage, "[api]") {
 			message = "Please provide an API Version ID. An API Version ID was expected; instead, an API ID was provided.\nPlease remove the --ver flag."
 		}
-		if debug {
-			fmt.Printf("%s : %s\n", resp.Status, apiInfo.FaultMessage)
+		if message != "" {
+			if debug {
+				log.Println(fault)
+			}
+			return errors.New(message)
 		}
-		return errors.New(message)
+	}
+	if err != nil {
+		return err
 	}
 	if useVersion {
 		var api cm.APIVersion
@@ -84,17 +81,14 @@ func ShowDetailsforAPIID(apiID string, useVersion bool, config control.Configura
 		if err != nil {
 			return err
 		}
-		outputAPIVersion(api)
-	} else {
-		var api cm.APIDetails
-		err = json.Unmarshal(bodyBytes, &api)
-		if err != nil {
-			return err
-		}
-		outputAPI(api)
+		return output.Render(api, format, func() { outputAPIVersion(api) })
+	}
+	var api cm.APIDetails
+	err = json.Unmarshal(bodyBytes, &api)
+	if err != nil {
+		return err
 	}
-	//fmt.Printf("%s: %s\n", resp.Status, bodyBytes)
-	return nil
+	return output.Render(api, format, func() { outputAPI(api) })
 }
 
 func outputAPIVersion(api cm.APIVersion) {
@@ -0,0 +1,35 @@
+package apis
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ghchinoy/atmotool/cmtest"
+	"github.com/ghchinoy/atmotool/output"
+)
+
+// TestShowDetailsWrongKindOfID is CM's 500 fault for a version ID given as an
+// API ID, and the reverse, which full GUIDs reach as they aren't looked up
+func TestShowDetailsWrongKindOfID(t *testing.T) {
+	s, _ := newClient(t)
+	api := s.AddAPI("Weather", "", "", "")
+	format, _ := output.NewOptions("json", "")
+
+	tests := []struct {
+		id         string
+		useVersion bool
+		want       string
+	}{
+		{api.VersionID + "." + cmtest.Tenant, false, "Please use the --ver flag"},
+		{api.ID + "." + cmtest.Tenant, true, "Please remove the --ver flag"},
+	}
+	for _, tt := range tests {
+		err := ShowDetailsforAPIID(tt.id, tt.useVersion, s.Config(), format, false)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("details of %s, --ver %v = %v, want %q", tt.id, tt.useVersion, err, tt.want)
+		}
+	}
+	if err := ShowDetailsforAPIID("Weather", false, s.Config(), format, false); err != nil {
+		t.Error(err)
+	}
+}
@@ -1,17 +1,14 @@
 package apis
 
 import (
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"net/http"
 	"sort"
-	"strings"
 
 	"github.com/fatih/structs"
 	"github.com/ghchinoy/atmotool/cm"
 	"github.com/ghchinoy/atmotool/control"
+	"github.com/ghchinoy/atmotool/output"
 )
 
 const (
@@ -22,9 +19,9 @@ const (
 	// CMCustomLessURI should be a template, subsitute in Configuration.Theme
 	CMCustomLessURI      = "/resources/theme/default/less?unpack=false"
 	CMListAPIsURI        = "/api/apis"
-	CMListAppsURI        = "/api/search?sortBy=com.soa.sort.order.alphabetical&count=20&start=0&q=type:app"
+	CMListAppsURI        = "/api/search?sortBy=com.soa.sort.order.alphabetical&q=type:app"
 	CMListPoliciesURI    = "/api/policies"
-	CMListUsersURI       = "/api/search?sort=asc&sortBy=com.soa.sort.order.title_sort&Federation=false&count=20&start=0&q=type:user"
+	CMListUsersURI       = "/api/search?sort=asc&sortBy=com.soa.sort.order.title_sort&Federation=false&q=type:user"
 	CMListAPIVersionsURI = "/api/apis/versions"
 )
 
@@ -56,56 +53,66 @@ func (slice APIs) Swap(i, j int) {
 	slice[i], slice[j] = slice[j], slice[i]
 }
 
-// APIListVersions outputs a list of all APIs
-func APIListVersions(config control.Configuration, debug bool) error {
-	if debug {
-		log.Println("Listing API Versions")
-	}
-	client, userinfo, err := control.LoginToCM(config, debug)
+// APIListVersions outputs a list of all API versions
+func APIListVersions(config control.Configuration, format output.Options, debug bool) error {
+	apiList, total, tenantID, err := GetAPIVersions(config, debug)
 	if err != nil {
-		log.Fatalln(err)
 		return err
 	}
+	return output.Render(apiList, format, func() {
+		fmt.Println(output.Count(len(apiList), total, "APIs"))
+		//pattern := "%-36s %-20s %-5s %-15s %s\n"
+		pattern := fmt.Sprintf("%%-%vs %%-%vs %%-%vs %%-%vs %%-%vs\n",
+			maxLengthOfField(apiList, "ID"),
+			maxLengthOfField(apiList, "Name"),
+			maxLengthOfField(apiList, "Version"),
+			maxLengthOfField(apiList, "Visibility"),
+			maxLengthOfField(apiList, "Endpoint"),
+		)
+		fmt.Printf(pattern, fmt.Sprintf("ID (%s)", tenantID), "Name", "Ver", "Vis", "Endpoint")
+
+		for _, v := range apiList {
+			fmt.Printf(pattern, v.ID, v.Name, v.Version, v.Visibility, v.Endpoint)
+		}
+	})
+}
 
-	url := config.URL + CMListAPIVersionsURI
-
-	req, err := http.NewRequest("GET", url, nil)
-	req.Header.Add("Accept", "application/json")
+// GetAPIVersions returns the API versions, sorted by name, with the tenant
+// suffix removed from their IDs, the total number of versions and the tenant
+func GetAPIVersions(config control.Configuration, debug bool) (apiList APIs, total int, tenantID string, err error) {
 	if debug {
-		log.Println("curl: ", control.CURLThis(client, req))
+		log.Println("Listing API Versions")
 	}
-	resp, err := client.Do(req)
-	defer resp.Body.Close()
-
-	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	client, err := control.NewClient(config, debug)
 	if err != nil {
-		return err
+		return nil, 0, "", err
 	}
-	if debug {
-		fmt.Printf("%s", bodyBytes)
+
+	items, total, err := client.GetAll(CMListAPIVersionsURI)
+	if err != nil {
+		return nil, 0, "", err
 	}
-	var apis cm.ApisResponse
-	err = json.Unmarshal(bodyBytes, &apis)
 	if debug {
-		log.Printf("Found %v APIs", len(apis.Channel.Items))
+		log.Printf("Found %v APIs", len(items))
 	}
 
-	tenantID := strings.Split(userinfo.LoginDomainID, ".")[1]
+	tenantID = client.Tenant()
 
-	var apiList APIs
-
-	fmt.Printf("%v APIs\n", len(apis.Channel.Items))
-	for _, v := range apis.Channel.Items {
+	for _, v := range items {
 		visibility := getVisibility(v)
 		var endpoint string
 		if len(v.Endpoints.Endpoint) > 0 {
 			endpoint = v.Endpoints.Endpoint[0].URI
 		}
+		var name string
+		if len(v.EntityReferences.EntityReference) > 0 {
+			name = v.EntityReferences.EntityReference[0].Title
+		}
 		// remove that tenant suffix from API guid
-		apiguid := strings.Replace(v.Guid.Value, "."+tenantID, "", -1)
+		apiguid := cm.ShortGUID(v.Guid.Value, tenantID)
 		apiList = append(apiList, API{
 			Version:    v.Title,
-			Name:       v.EntityReferences.EntityReference[0].Title,
+			Name:       name,
 			ID:         apiguid,
 			Endpoint:   endpoint,
 			Visibility: visibility,
@@ -114,85 +121,66 @@ func APIListVersions(config control.Configuration, debug bool) error {
 
 	sort.Sort(apiList)
 
-	//pattern := "%-36s %-20s %-5s %-15s %s\n"
-	pattern := fmt.Sprintf("%%-%vs %%-%vs %%-%vs %%-%vs %%-%vs\n",
-		maxLengthOfField(apiList, "ID"),
-		maxLengthOfField(apiList, "Name"),
-		maxLengthOfField(apiList, "Version"),
-		maxLengthOfField(apiList, "Visibility"),
-		maxLengthOfField(apiList, "Endpoint"),
-	)
-	fmt.Printf(pattern, fmt.Sprintf("ID (%s)", tenantID), "Name", "Ver", "Vis", "Endpoint")
+	return apiList, total, tenantID, nil
+}
 
-	for _, v := range apiList {
-		fmt.Printf(pattern, v.ID, v.Name, v.Version, v.Visibility, v.Endpoint)
+// APIList outputs a list of apis on the platform
+func APIList(config control.Configuration, format output.Options, debug bool) error {
+	apiList, total, tenantID, err := GetAPIs(config, debug)
+	if err != nil {
+		return err
 	}
-
-	return nil
+	return output.Render(apiList, format, func() {
+		fmt.Println(output.Count(len(apiList), total, "APIs"))
+		pattern := fmt.Sprintf("%%-%vs %%-%vs %%-%vs %%-%vs\n",
+			maxLengthOfField(apiList, "ID"),
+			maxLengthOfField(apiList, "Name"),
+			maxLengthOfField(apiList, "Version"),
+			maxLengthOfField(apiList, "VersionID"))
+		fmt.Printf(pattern, fmt.Sprintf("ID (%s)", tenantID), "Name", "Ver", "Ver ID")
+		for _, v := range apiList {
+			fmt.Printf(pattern, v.ID, v.Name, v.Version, v.VersionID)
+		}
+	})
 }
 
-// APIList returns a list of apis on the platform
-func APIList(config control.Configuration, debug bool) error {
+// GetAPIs returns the apis on the platform, sorted by name, with the tenant
+// suffix removed from their IDs, the total number of apis and the tenant
+func GetAPIs(config control.Configuration, debug bool) (apiList APIs, total int, tenantID string, err error) {
 	//var request *http.Request
 	if debug {
 		log.Println("Listing APIs")
 	}
-	client, userinfo, err := control.LoginToCM(config, debug)
+	client, err := control.NewClient(config, debug)
 	if err != nil {
-		log.Fatalln(err)
-		return err
-	}
-
-	url := config.URL + CMListAPIsURI
-
-	//client := &http.Client{}
-	req, err := http.NewRequest("GET", url, nil)
-	req.Header.Add("Accept", "application/json")
-	if debug {
-		log.Println("curl command:", control.CURLThis(client, req))
+		return nil, 0, "", err
 	}
-	resp, err := client.Do(req)
 
-	defer resp.Body.Close()
-
-	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	items, total, err := client.GetAll(CMListAPIsURI)
 	if err != nil {
-		return err
-	}
-	if debug {
-		fmt.Printf("%s", bodyBytes)
+		return nil, 0, "", err
 	}
-	var apis cm.ApisResponse
-	err = json.Unmarshal(bodyBytes, &apis)
 	if debug {
-		log.Printf("Found %v APIs", len(apis.Channel.Items))
+		log.Printf("Found %v APIs", len(items))
 	}
 
-	var apiList APIs
-
-	fmt.Printf("%v APIs\n", len(apis.Channel.Items))
-
 	// grab tenant suffix, for removal
 	if debug {
-		log.Printf("LoginDomainID: %s", userinfo.LoginDomainID)
+		log.Printf("LoginDomainID: %s", client.UserInfo.LoginDomainID)
 	}
-	tenantID := strings.Split(userinfo.LoginDomainID, ".")[1]
+	tenantID = client.Tenant()
 
-	for _, v := range apis.Channel.Items {
+	for _, v := range items {
 		if debug {
-			fmt.Printf("%s (%s)\n", v.EntityReference.Title, v.EntityReference.Guid)
+			log.Printf("%s (%s)\n", v.EntityReference.Title, v.EntityReference.Guid)
 		}
 		visibility := getVisibility(v)
 		// remove that tenant suffix from API guid
-		apiguid := strings.Replace(v.Guid.Value, "."+tenantID, "", -1)
-		versionguid := strings.Replace(v.EntityReference.Guid, "."+tenantID, "", -1)
-		latestversion := strings.Replace(v.EntityReference.Title, v.Title, "", -1)
-		latestversion = strings.Replace(latestversion, "(", "", -1)
-		latestversion = strings.Replace(latestversion, ")", "", -1)
-		latestversion = strings.TrimSpace(latestversion)
+		apiguid := cm.ShortGUID(v.Guid.Value, tenantID)
+		versionguid := cm.ShortGUID(v.EntityReference.Guid, tenantID)
 		apiList = append(apiList, API{
 			Name:       v.Title,
-			Version:    latestversion,
+			Version:    latestVersion(v),
 			ID:         apiguid,
 			Visibility: visibility,
 			VersionID:  versionguid,
@@ -200,17 +188,8 @@ func APIList(config control.Configuration, debug bool) error {
 	}
 
 	sort.Sort(apiList)
-	pattern := fmt.Sprintf("%%-%vs %%-%vs %%-%vs %%-%vs\n",
-		maxLengthOfField(apiList, "ID"),
-		maxLengthOfField(apiList, "Name"),
-		maxLengthOfField(apiList, "Version"),
-		maxLengthOfField(apiList, "VersionID"))
-	fmt.Printf(pattern, fmt.Sprintf("ID (%s)", tenantID), "Name", "Ver", "Ver ID")
-	for _, v := range apiList {
-		fmt.Printf(pattern, v.ID, v.Name, v.Version, v.VersionID)
-	}
 
-	return nil
+	return apiList, total, tenantID, nil
 }
 
 // probably add as method on APIs struct
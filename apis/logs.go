@@ -2,9 +2,7 @@ package apis
 
 import (
 	"fmt"
-	"io/ioutil"
 	"log"
-	"net/http"
 
 	"github.com/ghchinoy/atmotool/control"
 )
@@ -14,32 +12,28 @@ const (
 	CMExportUsageLogsFormat = "/api/apis/versions/%s/txlogs/export"
 )
 
-// APILogs lists logs for an API
+// APILogs lists logs for an API version; apiID is anything
+// ResolveAPIVersionID accepts
 func APILogs(apiID string, config control.Configuration, debug bool) error {
-	endpoint := fmt.Sprintf(CMExportUsageLogsFormat, apiID)
-	url := fmt.Sprintf("%s%s", config.URL, endpoint)
-	if debug {
-		log.Println("Listing logs for API", apiID)
-		log.Printf("Endpoint: %s", url)
-	}
-
-	client, _, err := control.LoginToCM(config, debug)
+	client, err := control.NewClient(config, debug)
 	if err != nil {
-		log.Fatalln(err)
 		return err
 	}
-	req, err := http.NewRequest("GET", url, nil)
-	req.Header.Add("Accept", "application/json")
-	resp, err := client.Do(req)
-	defer resp.Body.Close()
-
-	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	versionID, err := ResolveAPIVersionID(client, apiID, debug)
 	if err != nil {
 		return err
 	}
+
+	endpoint := fmt.Sprintf(CMExportUsageLogsFormat, versionID)
+	url := fmt.Sprintf("%s%s", config.URL, endpoint)
 	if debug {
-		log.Println(resp.Header.Get("Content-Type"))
-		log.Printf("%s", bodyBytes)
+		log.Println("Listing logs for API", apiID)
+		log.Printf("Endpoint: %s", url)
+	}
+	var bodyBytes []byte
+	err = client.Get(endpoint, &bodyBytes)
+	if err != nil {
+		return err
 	}
 
 	fmt.Printf("%s\n", bodyBytes)
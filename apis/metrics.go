@@ -1,13 +1,11 @@
 package apis
 
 import (
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"net/http"
 
 	"github.com/ghchinoy/atmotool/control"
+	"github.com/ghchinoy/atmotool/output"
 )
 
 const (
@@ -41,6 +39,7 @@ type MetricNameValue struct {
 
 // Metric is a convenience struct
 type Metric struct {
+	StartTime       string
 	AvgResponseTime int
 	MinResponseTime int
 	MaxResponseTime int
@@ -50,51 +49,55 @@ type Metric struct {
 }
 
 // APIMetrics lists metrics of an API
-func APIMetrics(apiID string, config control.Configuration, debug bool) error {
+func APIMetrics(apiID string, config control.Configuration, format output.Options, debug bool) error {
+	metrics, err := GetAPIMetrics(apiID, config, debug)
+	if err != nil {
+		return err
+	}
+	return output.Render(metrics, format, func() {
+		fmt.Println("Metrics for API ", apiID)
+		pattern := "%-20s %-5v %-5v %-5v %-5v %-5v %-5v\n"
+		fmt.Printf(pattern, "start", "avg", "min", "max", "tot", "succ", "fault")
+		for _, m := range metrics {
+			fmt.Printf(pattern,
+				m.StartTime,
+				m.AvgResponseTime,
+				m.MinResponseTime,
+				m.MaxResponseTime,
+				m.TotalCount,
+				m.SuccessCount,
+				m.FaultCount)
+		}
+	})
+}
+
+// GetAPIMetrics returns the metrics of an API version, one Metric per
+// interval. apiID is anything ResolveAPIVersionID accepts.
+func GetAPIMetrics(apiID string, config control.Configuration, debug bool) ([]Metric, error) {
 	var metrics MetricsResponse
 	if debug {
 		log.Println("Getting metrics for", apiID)
 	}
-	endpoint := fmt.Sprintf(GetMetricsFormat, apiID)
-	url := fmt.Sprintf("%s%s", config.URL, endpoint)
-
-	client, _, err := control.LoginToCM(config, debug)
+	client, err := control.NewClient(config, debug)
 	if err != nil {
-		log.Fatalln(err)
-		return err
+		return nil, err
 	}
-	req, err := http.NewRequest("GET", url, nil)
-	req.Header.Add("Accept", "application/json")
-	resp, err := client.Do(req)
-	defer resp.Body.Close()
-
-	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	versionID, err := ResolveAPIVersionID(client, apiID, debug)
 	if err != nil {
-		return err
-	}
-	if debug {
-		log.Printf("%s", bodyBytes)
+		return nil, err
 	}
-	err = json.Unmarshal(bodyBytes, &metrics)
+	err = client.Get(fmt.Sprintf(GetMetricsFormat, versionID), &metrics)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	fmt.Println("Metrics for API ", apiID)
-	format := "%-20s %-5v %-5v %-5v %-5v %-5v %-5v\n"
-	fmt.Printf(format, "start", "avg", "min", "max", "tot", "succ", "fault")
+
+	var intervals []Metric
 	for _, v := range metrics.Interval {
 		m := mapMetrics(v.Metrics)
-		fmt.Printf(format,
-			v.StartTime,
-			m.AvgResponseTime,
-			m.MinResponseTime,
-			m.MaxResponseTime,
-			m.TotalCount,
-			m.SuccessCount,
-			m.FaultCount)
+		m.StartTime = v.StartTime
+		intervals = append(intervals, m)
 	}
-
-	return nil
+	return intervals, nil
 }
 
 // mapMetrics turns a metric name/value pair into a Metric object
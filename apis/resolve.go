@@ -0,0 +1,236 @@
+package apis
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/ghchinoy/atmotool/cm"
+	"github.com/ghchinoy/atmotool/control"
+)
+
+// errVersionID is returned by ResolveAPIID for the ID of an API version
+var errVersionID = errors.New("an API version ID was given instead of an API ID")
+
+// candidate is an API, or an API version, that a reference may name
+type candidate struct {
+	id        string
+	name      string
+	version   string
+	apiID     string
+	versionID string
+}
+
+func (c candidate) String() string {
+	return fmt.Sprintf("%s (%s %s)", c.id, c.name, c.version)
+}
+
+// ResolveAPIID returns the full ID of the API that ref names. ref can be the
+// full id.tenant GUID, which is returned without listing the APIs, the ID
+// without its tenant as shown by apis list, the API's name, or name:version.
+func ResolveAPIID(client *control.Client, ref string, debug bool) (string, error) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return "", fmt.Errorf("Please provide an API ID or name")
+	}
+	tenant := client.Tenant()
+	if isFullGUID(ref, tenant) {
+		return ref, nil
+	}
+	apis, err := apiCandidates(client)
+	if err != nil {
+		return "", err
+	}
+	matches := match(apis, ref, tenant)
+	if len(matches) == 0 {
+		// name:version names a version, of the API wanted
+		versions, err := versionCandidates(client)
+		if err != nil {
+			return "", err
+		}
+		for _, v := range match(versions, ref, tenant) {
+			if idMatches(v.id, ref, tenant) {
+				return "", fmt.Errorf("%w: %s", errVersionID, v)
+			}
+			v.id = v.apiID
+			matches = append(matches, v)
+		}
+	}
+	c, err := one(matches, "API", ref)
+	if err != nil {
+		return "", err
+	}
+	if debug {
+		log.Printf("API %q is %s", ref, c)
+	}
+	return c.id, nil
+}
+
+// ResolveAPIVersionID returns the full ID of the API version that ref names.
+// ref can be the version's full GUID, which is returned without listing the
+// versions, its short ID, name:version, or anything ResolveAPIID accepts,
+// for the API's latest version.
+func ResolveAPIVersionID(client *control.Client, ref string, debug bool) (string, error) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return "", fmt.Errorf("Please provide an API version ID or name:version")
+	}
+	tenant := client.Tenant()
+	if isFullGUID(ref, tenant) {
+		return ref, nil
+	}
+	versions, err := versionCandidates(client)
+	if err != nil {
+		return "", err
+	}
+	matches := match(versions, ref, tenant)
+	if len(matches) == 0 {
+		// an API, for its latest version
+		apis, err := apiCandidates(client)
+		if err != nil {
+			return "", err
+		}
+		for _, v := range match(apis, ref, tenant) {
+			v.id = v.versionID
+			matches = append(matches, v)
+		}
+	}
+	c, err := one(matches, "API version", ref)
+	if err != nil {
+		return "", err
+	}
+	if debug {
+		log.Printf("API version %q is %s", ref, c)
+	}
+	return c.id, nil
+}
+
+// apiCandidates lists all APIs, with their latest version
+func apiCandidates(client *control.Client) ([]candidate, error) {
+	items, err := getAllItems(client, CMListAPIsURI)
+	if err != nil {
+		return nil, err
+	}
+	var list []candidate
+	for _, v := range items {
+		list = append(list, candidate{
+			id:        v.Guid.Value,
+			name:      v.Title,
+			version:   latestVersion(v),
+			apiID:     v.Guid.Value,
+			versionID: v.EntityReference.Guid,
+		})
+	}
+	return list, nil
+}
+
+// versionCandidates lists all API versions
+func versionCandidates(client *control.Client) ([]candidate, error) {
+	items, err := getAllItems(client, CMListAPIVersionsURI)
+	if err != nil {
+		return nil, err
+	}
+	var list []candidate
+	for _, v := range items {
+		c := candidate{id: v.Guid.Value, version: v.Title, versionID: v.Guid.Value}
+		if len(v.EntityReferences.EntityReference) > 0 {
+			c.name = v.EntityReferences.EntityReference[0].Title
+			c.apiID = v.EntityReferences.EntityReference[0].Guid
+		}
+		list = append(list, c)
+	}
+	return list, nil
+}
+
+// getAllItems pages through a listing, ignoring --limit, which is meant for
+// what's output rather than what's looked up
+func getAllItems(client *control.Client, path string) ([]cm.Item, error) {
+	all := *client
+	all.Config.Limit = 0
+	items, _, err := all.GetAll(path)
+	return items, err
+}
+
+// isFullGUID reports whether ref is an id.tenant GUID of the tenant, which
+// is used as it is, without looking it up
+func isFullGUID(ref string, tenant string) bool {
+	return tenant != "" && strings.HasSuffix(ref, "."+tenant)
+}
+
+// idMatches reports whether ref is the GUID id, with or without its tenant.
+// When the tenant isn't known, any suffix after the ID is taken as it.
+func idMatches(id string, ref string, tenant string) bool {
+	if id == ref {
+		return true
+	}
+	if tenant == "" {
+		return strings.HasPrefix(id, ref+".")
+	}
+	return id == cm.FullGUID(ref, tenant)
+}
+
+// match returns the candidates ref names, trying in turn an ID, with or
+// without its tenant, name:version and a name, ignoring case for names
+func match(list []candidate, ref string, tenant string) []candidate {
+	var matches []candidate
+	for _, c := range list {
+		if idMatches(c.id, ref, tenant) {
+			matches = append(matches, c)
+		}
+	}
+	if len(matches) > 0 {
+		return matches
+	}
+	if i := strings.LastIndex(ref, ":"); i > 0 {
+		name, version := ref[:i], ref[i+1:]
+		for _, c := range list {
+			if strings.EqualFold(c.name, name) && c.version == version {
+				matches = append(matches, c)
+			}
+		}
+		if len(matches) > 0 {
+			return matches
+		}
+	}
+	for _, c := range list {
+		if strings.EqualFold(c.name, ref) {
+			matches = append(matches, c)
+		}
+	}
+	return matches
+}
+
+// one returns the single match, or an error listing the candidates
+func one(matches []candidate, kind string, ref string) (candidate, error) {
+	// versions of one API resolve to the same API ID
+	seen := map[string]bool{}
+	var unique []candidate
+	for _, c := range matches {
+		if !seen[c.id] {
+			seen[c.id] = true
+			unique = append(unique, c)
+		}
+	}
+	switch len(unique) {
+	case 0:
+		return candidate{}, fmt.Errorf("No %s found for %q, see apis list or apis listversions", kind, ref)
+	case 1:
+		return unique[0], nil
+	}
+	var names []string
+	for _, c := range unique {
+		names = append(names, "  "+c.String())
+	}
+	return candidate{}, fmt.Errorf("%q matches %d of the %ss, use one of their IDs or name:version:\n%s",
+		ref, len(unique), kind, strings.Join(names, "\n"))
+}
+
+// latestVersion is the version of an API's latest version, from its
+// "name (version)" entity reference
+func latestVersion(v cm.Item) string {
+	latestversion := strings.Replace(v.EntityReference.Title, v.Title, "", -1)
+	latestversion = strings.Replace(latestversion, "(", "", -1)
+	latestversion = strings.Replace(latestversion, ")", "", -1)
+	return strings.TrimSpace(latestversion)
+}
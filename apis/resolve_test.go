@@ -0,0 +1,139 @@
+package apis
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ghchinoy/atmotool/cmtest"
+	"github.com/ghchinoy/atmotool/control"
+)
+
+// newClient starts a fake CM and logs in to it, caching the login in a
+// temporary home
+func newClient(t *testing.T) (*cmtest.Server, *control.Client) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	s := cmtest.NewServer()
+	t.Cleanup(s.Close)
+	client, err := control.NewClient(s.Config(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, client
+}
+
+func TestResolveAPIID(t *testing.T) {
+	s, client := newClient(t)
+	weather := s.AddAPI("Weather", "1.0", "", "")
+	weather2 := s.AddAPI("Weather", "2.0", "", "")
+	news := s.AddAPI("News", "1.0", "", "")
+	full := func(id string) string { return id + "." + cmtest.Tenant }
+
+	tests := []struct {
+		ref  string
+		want string
+		err  string
+	}{
+		{news.ID, full(news.ID), ""},
+		{full(news.ID), full(news.ID), ""},
+		{"news", full(news.ID), ""},
+		{" News ", full(news.ID), ""},
+		{"Weather:2.0", full(weather2.ID), ""},
+		{"weather:1.0", full(weather.ID), ""},
+		{"Weather", "", `"Weather" matches 2 of the APIs`},
+		{"Weather:3.0", "", "No API found"},
+		{"Sports", "", "No API found"},
+		{"", "", "Please provide"},
+	}
+	for _, tt := range tests {
+		got, err := ResolveAPIID(client, tt.ref, false)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("ResolveAPIID(%q) = %q, %v, want an error with %q", tt.ref, got, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ResolveAPIID(%q) = %q, %v, want %q", tt.ref, got, err, tt.want)
+		}
+	}
+
+	_, err := ResolveAPIID(client, weather2.VersionID, false)
+	if !errors.Is(err, errVersionID) {
+		t.Errorf("ResolveAPIID of a version ID = %v, want errVersionID", err)
+	}
+}
+
+func TestResolveAPIVersionID(t *testing.T) {
+	s, client := newClient(t)
+	weather := s.AddAPI("Weather", "1.0", "", "")
+	weather2 := s.AddAPI("Weather", "2.0", "", "")
+	news := s.AddAPI("News", "1.0", "", "")
+	full := func(id string) string { return id + "." + cmtest.Tenant }
+
+	tests := []struct {
+		ref  string
+		want string
+		err  string
+	}{
+		{weather.VersionID, full(weather.VersionID), ""},
+		{full(weather2.VersionID), full(weather2.VersionID), ""},
+		{"Weather:2.0", full(weather2.VersionID), ""},
+		{news.ID, full(news.VersionID), ""},
+		{"News", full(news.VersionID), ""},
+		{"Weather", "", `"Weather" matches 2 of the API versions`},
+		{"News:2.0", "", "No API version found"},
+	}
+	for _, tt := range tests {
+		got, err := ResolveAPIVersionID(client, tt.ref, false)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("ResolveAPIVersionID(%q) = %q, %v, want an error with %q", tt.ref, got, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ResolveAPIVersionID(%q) = %q, %v, want %q", tt.ref, got, err, tt.want)
+		}
+	}
+}
+
+// TestResolveFullGUID is a full GUID, used as it is, even when it isn't
+// listed
+func TestResolveFullGUID(t *testing.T) {
+	s, client := newClient(t)
+	s.AddAPI("Weather", "", "", "")
+	before := len(s.Requests())
+
+	ref := "f00d." + cmtest.Tenant
+	if got, err := ResolveAPIID(client, ref, false); err != nil || got != ref {
+		t.Errorf("ResolveAPIID(%q) = %q, %v", ref, got, err)
+	}
+	if got, err := ResolveAPIVersionID(client, ref, false); err != nil || got != ref {
+		t.Errorf("ResolveAPIVersionID(%q) = %q, %v", ref, got, err)
+	}
+	if got := s.Requests()[before:]; len(got) != 0 {
+		t.Errorf("resolving full GUIDs listed %v", got)
+	}
+}
+
+// TestResolveUnknownTenant resolves short IDs when neither the
+// configuration nor the login names the tenant
+func TestResolveUnknownTenant(t *testing.T) {
+	s, client := newClient(t)
+	news := s.AddAPI("News", "", "", "")
+	client.UserInfo.LoginDomainID = ""
+	if client.Tenant() != "" {
+		t.Fatalf("tenant = %q", client.Tenant())
+	}
+	want := news.ID + "." + cmtest.Tenant
+	for _, ref := range []string{news.ID, want, "News"} {
+		if got, err := ResolveAPIID(client, ref, false); err != nil || got != want {
+			t.Errorf("ResolveAPIID(%q) = %q, %v, want %q", ref, got, err, want)
+		}
+	}
+	if got, err := ResolveAPIVersionID(client, news.ID, false); err != nil || got != news.VersionID+"."+cmtest.Tenant {
+		t.Errorf("ResolveAPIVersionID(%q) = %q, %v", news.ID, got, err)
+	}
+}
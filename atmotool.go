@@ -4,14 +4,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"log"
-	"mime/multipart"
-	"net/http"
 	"net/url"
 	"os"
-	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
@@ -19,14 +14,14 @@ import (
 	"github.com/ghchinoy/atmotool/apis"
 	"github.com/ghchinoy/atmotool/cm"
 	"github.com/ghchinoy/atmotool/control"
+	"github.com/ghchinoy/atmotool/output"
 	"github.com/ghchinoy/atmotool/policies"
+	"github.com/ghchinoy/atmotool/search"
 	"github.com/ghchinoy/atmotool/users"
 	"github.com/ghchinoy/atmotool/version"
 	"github.com/ghchinoy/atmotool/zip"
 	"github.com/ryanuber/columnize"
 
-	"bytes"
-
 	"github.com/docopt/docopt-go"
 )
 
@@ -88,13 +83,13 @@ func (slice Apps) Swap(i, j int) {
 
 // User is a convenience structure for a CM User
 type User struct {
-	Name        string
-	ProfileName string
-	Version     string
-	ID          string
-	Domain      string
-	Email       string
-	UserName    string
+	Name        string `json:"name"`
+	ProfileName string `json:"profileName"`
+	Version     string `json:"version"`
+	ID          string `json:"id"`
+	Domain      string `json:"domain"`
+	Email       string `json:"email"`
+	UserName    string `json:"userName"`
 }
 
 // Users is a collection of API structs
@@ -115,9 +110,9 @@ func (slice Users) Swap(i, j int) {
 	slice[i], slice[j] = slice[j], slice[i]
 }
 
-// TODO this const block is replicated in api/list.go
-//  make sure the appropriate consts are in the appropriate command code files
-//  remove unnecessary from here
+// TODO this const block is replicated in api/list.go,
+// make sure the appropriate consts are in the appropriate command code files
+// and remove unnecessary ones from here
 const (
 	CMLandingIndex         = "/content/home/landing/index.htm"
 	CMInternationalization = "/i18n"
@@ -125,14 +120,14 @@ const (
 	CMFavicon              = "/style/images/favicon.ico"
 	// CMCustomLessURI should be a template, subsitute in Configuration.Theme
 	CMCustomLessURI = "/resources/theme/default/less?unpack=false"
-	CMListAppsURI   = "/api/search?sortBy=com.soa.sort.order.alphabetical&count=20&start=0&q=type:app"
-	CMListUsersURI  = "/api/search?sort=asc&sortBy=com.soa.sort.order.title_sort&Federation=false&count=20&start=0&q=type:user"
+	CMListAppsURI   = "/api/search?sortBy=com.soa.sort.order.alphabetical&q=type:app"
+	CMListUsersURI  = "/api/search?sort=asc&sortBy=com.soa.sort.order.title_sort&Federation=false&q=type:user"
 )
 
 var (
 	config control.Configuration
-	client *http.Client
-	jar    http.CookieJar
+	client *control.Client
+	format output.Options
 	debug  bool
 )
 
@@ -142,26 +137,46 @@ func main() {
 
 Usage:
   atmotool zip --prefix <prefix> <dir>
-  atmotool upload less <file> [--config <config>] [--debug]
-  atmotool upload file --path <path> <files>... [--config <config>] [--debug]
-  atmotool download --path <path> <filename> [--config <config>] [--debug]
-  atmotool list apis [--config <config>] [--debug]
-  atmotool apis list [--config <config>] [--debug]
-  atmotool apis listversions [--config <config>] [--debug]
-  atmotool apis metrics <apiId> [--config <config>] [--debug]
-  atmotool apis logs <apiId> [--config <config>] [--debug]
-  atmotool apis create <apiName> [--from <serviceID> | --spec <spec>] [--endpoint <endpoint>] [--config <config>] [--debug]
-  atmotool apis details <apiID> [--ver] [--config <config>] [--debug]
-  atmotool policies list [--types <types>] [--config <config>] [--debug]
-  atmotool list topapis [--config <config>] [--debug]
-  atmotool list apps [--config <config>] [--debug]
-  atmotool list users [--config <config>] [--debug]
-  atmotool users delete <userlist> [--config <config>] [--debug]
-  atmotool list policies [--config <config>] [--debug]
-  atmotool list cms [<path>] [--config <config>] [--debug]
-  atmotool cms list [<path>] [--config <config>] [--debug]
-  atmotool rebuild [<theme>] [--config <config>] [--debug]
-  atmotool reset [<theme>] [--config <config>] [--debug]
+  atmotool upload less <file> [options]
+  atmotool upload file [--path <path>] <files>... [--unpack | --no-unpack] [--continue-on-error] [options]
+  atmotool upload dir <localdir> --path <path> [options]
+  atmotool upload all [options]
+  atmotool deploy [<manifest>] [options]
+  atmotool download --path <path> <filename> [options]
+  atmotool list apis [options]
+  atmotool apis list [options]
+  atmotool apis listversions [options]
+  atmotool apis metrics <apiId> [options]
+  atmotool apis logs <apiId> [options]
+  atmotool apis create <apiName> [--from <serviceID> | --spec <spec>] [--endpoint <endpoint>] [options]
+  atmotool apis details <apiID> [--ver] [options]
+  atmotool policies list [--types <types>] [options]
+  atmotool list topapis [options]
+  atmotool list apps [options]
+  atmotool list users [options]
+  atmotool users delete <userlist> [options]
+  atmotool list policies [options]
+  atmotool list cms [<path>] [--depth <n>] [--dirs-only] [options]
+  atmotool cms list [<path>] [--depth <n>] [--dirs-only] [options]
+  atmotool cms sync <localdir> <cmspath> [--delete] [options]
+  atmotool cms diff <localdir> <cmspath> [options]
+  atmotool cms cat <path> [options]
+  atmotool cms get <path> [<dest>] [options]
+  atmotool cms put <file> <path> [options]
+  atmotool cms rm [-r] <path> [options]
+  atmotool cms mkdir <path> [options]
+  atmotool cms mv <src> <dest> [options]
+  atmotool cms cp <src> <dest> [options]
+  atmotool search <query> [--type <type>] [--sort-by <sort>] [--federation] [options]
+  atmotool watch <dir> [--theme <theme>] [options]
+  atmotool rebuild [<theme>] [options]
+  atmotool reset [<theme>] [--profile <name>] [--only <selectors>] [--except <selectors>] [--yes] [--no-backup] [options]
+  atmotool backup [--paths <paths>] <archive> [options]
+  atmotool restore <archive> [options]
+  atmotool logout [options]
+  atmotool config list [options]
+  atmotool config use <name> [options]
+  atmotool config show [<name>] [options]
   atmotool -h | --help
   atmotool --version
   atmotool version
@@ -171,9 +186,36 @@ Options:
   --version  Show version and exit.
   --dir=<dir>  Directory. [default: .]
   --path=<cms_path>  CM CMS path.
-  --config=<config> Configuration file [default: local.conf]
+  --config=<config>  Configuration file, defaults to ./local.conf, ~/.akana/config or ~/.akana/local.conf.
+  --env=<env>  Profile to use from a profiles configuration file, instead of its current profile.
+  --timeout=<duration>  How long to wait for CM to respond, or for more of a response, ex. 30s, 0 waits forever. Overrides timeout in the configuration.
+  --record=<dir>  Save every CM request and response to fixture files in dir.
+  --replay=<dir>  Answer CM requests from fixture files in dir, without a network.
+  --dry-run  Print the curl commands for changes to CM instead of making them.
+  --output=<format>  Output format of lists and details: table, json, yaml, csv or template. [default: table]
+  --template=<template>  Go text/template to output lists and details with.
+  --depth=<n>  Levels of CMS folders to list, all by default.
+  --dirs-only  List CMS folders only, not files.
+  -r --recursive  Delete a CMS folder and everything in it.
+  --delete  Delete what's in the CMS path but not in the local directory.
+  --type=<type>  Type of entity to search for: api, app, user, group or board.
+  --sort-by=<sort>  Search sort order, ex. alphabetical, title_sort or rating.
+  --federation  Include results from federated tenants in a search.
+  --theme=<theme>  Theme to upload changes to and rebuild, defaults to theme in the configuration or default.
+  --paths=<paths>  Comma separated CMS paths to back up, /content and /resources by default.
+  --no-backup  Reset without first backing up what's deleted.
+  --profile=<name>  Reset profile: full, styles, landing or one of resetProfiles in the configuration, full by default.
+  --only=<selectors>  Comma separated CMS paths or names to reset, of those in the profile.
+  --except=<selectors>  Comma separated CMS paths or names not to reset.
+  --yes  Reset without asking first.
+  --unpack  Unpack every upload, not only zips.
+  --no-unpack  Store zips as they are instead of unpacking them.
+  --continue-on-error  Upload the rest of the files after one fails, and list the failures at the end.
+  --limit=<n>  Most items to list, all by default.
+  --page-size=<n>  Items to request from CM per page of a list. Overrides pageSize in the configuration.
+  --quiet  Don't show upload progress.
+  --debug  Debug output.
 `
-	//   atmotool upload all --config <config> [--dir <dir>]
 
 	arguments, _ := docopt.Parse(usage, nil, true, version.Version(), false)
 
@@ -197,33 +239,60 @@ Options:
 		debug = true
 		log.Println("Debug output requested.")
 	}
+	exitOnError(initOutput(arguments))
 
-	if arguments["upload"] == true {
-		// Upload
-		configLocation, _ := arguments["--config"].(string)
-		config, err := control.InitializeConfiguration(configLocation, debug)
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+	if arguments["config"] == true {
+		// Configuration profiles
+		location, _ := arguments["--config"].(string)
+		if location == "" {
+			location = control.DefaultProfilesLocation()
+		}
+		if arguments["list"] == true {
+			exitOnError(listProfiles(location))
+		} else if arguments["use"] == true {
+			name, _ := arguments["<name>"].(string)
+			exitOnError(control.UseProfile(location, name))
+			fmt.Printf("Switched to profile %s\n", name)
+		} else if arguments["show"] == true {
+			name, _ := arguments["<name>"].(string)
+			if name == "" {
+				name, _ = arguments["--env"].(string)
+			}
+			exitOnError(showProfile(location, name))
 		}
 
+	} else if arguments["upload"] == true {
+		// Upload
+		var err error
+		config, err = initConfig(arguments)
+		exitOnError(err)
+
 		if arguments["less"] == true {
 			// Upload Less
 			uploadFilePath := arguments["<file>"].(string)
-			uploadLessFile(uploadFilePath, config)
+			err = uploadLessFile(uploadFilePath, config)
+		} else if arguments["dir"] == true {
+			// Upload a directory
+			localDir, _ := arguments["<localdir>"].(string)
+			path, _ := arguments["--path"].(string)
+			err = uploadDir(localDir, path)
 		} else if arguments["all"] == true {
-			// Upload all
+			// Upload all, as the deploy manifest in dir says
 			dir, _ := arguments["--dir"].(string)
-			uploadAllHelper(dir, config)
+			err = deploy(dir)
 		} else if arguments["file"] == true {
 			// Upload file
-			var files []string
-			for _, v := range arguments["<files>"].([]string) {
-				files = append(files, v)
-			}
+			files, _ := arguments["<files>"].([]string)
 			path, _ := arguments["--path"].(string)
-			upload(files, config, path)
+			var unpack *bool
+			if arguments["--unpack"] == true || arguments["--no-unpack"] == true {
+				u := arguments["--unpack"] == true
+				unpack = &u
+			}
+			keepGoing, _ := arguments["--continue-on-error"].(bool)
+			err = upload(files, path, unpack, keepGoing)
 		}
+		exitOnError(err)
 
 	} else if arguments["zip"] == true {
 		// Zip
@@ -242,15 +311,12 @@ Options:
 		fn = prefix + "_" + fn + ".zip"
 		fmt.Printf("Zipping %s as %s...\n", dir, fn)
 
-		zip.ZipFolder(dir, fn)
+		exitOnError(zip.ZipFolder(dir, fn))
 	} else if arguments["rebuild"] == true {
 		// Rebuild
-		configLocation, _ := arguments["--config"].(string)
-		config, err := control.InitializeConfiguration(configLocation, debug)
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
+		var err error
+		config, err = initConfig(arguments)
+		exitOnError(err)
 
 		theme := config.Theme
 
@@ -258,40 +324,32 @@ Options:
 			theme = "default"
 		}
 		// override config from cmdline
-		theme, _ = arguments["<theme>"].(string)
+		if t, ok := arguments["<theme>"].(string); ok {
+			theme = t
+		}
 
 		if debug {
 			log.Println("Rebuilding styles for theme:", theme)
 		}
 
-		err = rebuildStyles(config, theme)
-		if err != nil {
-			log.Println(err)
-		}
+		exitOnError(rebuildStyles(config, theme))
 	} else if arguments["apis"] == true {
 		// APIs
-		configLocation, _ := arguments["--config"].(string)
-		config, err := control.InitializeConfiguration(configLocation, debug)
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
+		var err error
+		config, err = initConfig(arguments)
+		exitOnError(err)
 		if arguments["list"] == true {
 			// List APIs
-			apis.APIList(config, debug)
+			err = apis.APIList(config, format, debug)
 		} else if arguments["listversions"] == true {
-			apis.APIListVersions(config, debug)
+			err = apis.APIListVersions(config, format, debug)
 		} else if arguments["metrics"] == true {
 			apiID, _ := arguments["<apiId>"].(string)
 			if len(apiID) == 0 {
 				fmt.Println("Unable to determine API ID.")
 				os.Exit(1)
 			}
-			err := apis.APIMetrics(apiID, config, debug)
-			if err != nil {
-				fmt.Println(err.Error())
-				os.Exit(1)
-			}
+			err = apis.APIMetrics(apiID, config, format, debug)
 			// if <method>
 			//apiMetricsForMethod(apiID, method)
 		} else if arguments["logs"] == true {
@@ -301,11 +359,7 @@ Options:
 				fmt.Println("Unable to determine API ID.")
 				os.Exit(1)
 			}
-			err := apis.APILogs(apiID, config, debug)
-			if err != nil {
-				log.Println(err.Error())
-				os.Exit(1)
-			}
+			err = apis.APILogs(apiID, config, debug)
 		} else if arguments["create"] == true {
 			// Create
 			// atmotool apis create APINAME
@@ -321,20 +375,20 @@ Options:
 			if from != "" {
 				// Create from existing service
 				// .. --from APIID
-				apis.CreateAPIfromExistingService(apiName, from, config, debug)
+				err = apis.CreateAPIfromExistingService(apiName, from, config, debug)
 			} else if spec != "" {
 				// Create using a provied spec
 				// --spec SPECFILE
 				// TODO
-				apis.CreateAPIwithSpec(apiName, spec, config, debug)
+				err = apis.CreateAPIwithSpec(apiName, spec, config, debug)
 			} else {
 				// Add name only
 				if endpoint != "" {
 					// ... --endpoint HTTP
-					apis.CreateAPINameOnlyWithEndpoint(apiName, endpoint, config, debug)
+					err = apis.CreateAPINameOnlyWithEndpoint(apiName, endpoint, config, debug)
 				} else {
 					// atmotool apis create APINAME
-					apis.CreateAPINameOnly(apiName, config, debug)
+					err = apis.CreateAPINameOnly(apiName, config, debug)
 				}
 			}
 
@@ -346,56 +400,103 @@ Options:
 				os.Exit(1)
 			}
 			useVersion, _ := arguments["--ver"].(bool)
-			if err := apis.ShowDetailsforAPIID(apiID, useVersion, config, debug); err != nil {
-				fmt.Println(err.Error())
-				os.Exit(1)
-			}
-
+			err = apis.ShowDetailsforAPIID(apiID, useVersion, config, format, debug)
 		}
+		exitOnError(err)
 
 	} else if arguments["cms"] == true {
 		// CMS
-		configLocation, _ := arguments["--config"].(string)
 		var err error
-		config, err = control.InitializeConfiguration(configLocation, debug)
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
+		config, err = initConfig(arguments)
+		exitOnError(err)
 		if arguments["list"] == true {
 
+			err = listCMSArguments(arguments)
+		} else if arguments["sync"] == true {
+			localDir, _ := arguments["<localdir>"].(string)
+			cmsPath, _ := arguments["<cmspath>"].(string)
+			remove, _ := arguments["--delete"].(bool)
+			err = cmsSync(localDir, cmsPath, remove)
+		} else if arguments["diff"] == true {
+			localDir, _ := arguments["<localdir>"].(string)
+			cmsPath, _ := arguments["<cmspath>"].(string)
+			err = cmsDiff(localDir, cmsPath)
+		} else if arguments["cat"] == true {
 			path, _ := arguments["<path>"].(string)
-			if len(path) == 0 {
-				listTopLevelCMS()
-			} else {
-				listCMS(path, 0)
-			}
+			err = cmsCat(path)
+		} else if arguments["get"] == true {
+			path, _ := arguments["<path>"].(string)
+			dest, _ := arguments["<dest>"].(string)
+			err = cmsGet(path, dest)
+		} else if arguments["put"] == true {
+			file, _ := arguments["<file>"].(string)
+			path, _ := arguments["<path>"].(string)
+			err = cmsPut(file, path)
+		} else if arguments["rm"] == true {
+			path, _ := arguments["<path>"].(string)
+			recursive, _ := arguments["--recursive"].(bool)
+			err = cmsRemove(path, recursive)
+		} else if arguments["mkdir"] == true {
+			path, _ := arguments["<path>"].(string)
+			err = cmsMkdir(path)
+		} else if arguments["mv"] == true || arguments["cp"] == true {
+			src, _ := arguments["<src>"].(string)
+			dest, _ := arguments["<dest>"].(string)
+			err = cmsCopy(src, dest, arguments["mv"] == true)
 		}
+		exitOnError(err)
 
-	} else if arguments["policies"] == true {
-		configLocation, _ := arguments["--config"].(string)
+	} else if arguments["deploy"] == true {
+		// Deploy
 		var err error
-		config, err = control.InitializeConfiguration(configLocation, debug)
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+		config, err = initConfig(arguments)
+		exitOnError(err)
+		manifest, ok := arguments["<manifest>"].(string)
+		if !ok {
+			manifest = deployManifestName
 		}
+		exitOnError(deploy(manifest))
+
+	} else if arguments["watch"] == true {
+		// Watch
+		var err error
+		config, err = initConfig(arguments)
+		exitOnError(err)
+		dir, _ := arguments["<dir>"].(string)
+		theme, ok := arguments["--theme"].(string)
+		if !ok {
+			theme = config.Theme
+		}
+		exitOnError(watchTheme(dir, theme))
+
+	} else if arguments["search"] == true {
+		// Search
+		var err error
+		config, err = initConfig(arguments)
+		exitOnError(err)
+		query, _ := arguments["<query>"].(string)
+		var opts search.Options
+		opts.Type, _ = arguments["--type"].(string)
+		opts.SortBy, _ = arguments["--sort-by"].(string)
+		opts.Federation, _ = arguments["--federation"].(bool)
+		exitOnError(search.Search(query, opts, config, format, debug))
+
+	} else if arguments["policies"] == true {
+		var err error
+		config, err = initConfig(arguments)
+		exitOnError(err)
 		policytypes, ok := arguments["<types>"].(string)
 		if !ok {
 			policytypes = "all"
 		}
-		policies.ListPolicies(policytypes, config, debug)
+		exitOnError(policies.ListPolicies(policytypes, config, debug))
 
 	} else if arguments["list"] == true {
 		// List policies
 		// List APIs
-		configLocation, _ := arguments["--config"].(string)
 		var err error
-		config, err = control.InitializeConfiguration(configLocation, debug)
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
+		config, err = initConfig(arguments)
+		exitOnError(err)
 
 		if arguments["policies"] == true {
 
@@ -403,50 +504,39 @@ Options:
 			if !ok {
 				policytypes = "all"
 			}
-			policies.ListPolicies(policytypes, config, debug)
+			err = policies.ListPolicies(policytypes, config, debug)
 		} else if arguments["apis"] == true {
 			//listApis()
-			apis.APIList(config, debug)
+			err = apis.APIList(config, format, debug)
 		} else if arguments["apps"] == true {
-			listApps()
+			err = listApps()
 		} else if arguments["users"] == true {
-			listUsers()
+			err = listUsers()
 		} else if arguments["topapis"] == true {
-			listTopApis()
+			err = listTopApis()
 		} else if arguments["cms"] == true {
 
-			path, _ := arguments["<path>"].(string)
-			if len(path) == 0 {
-				listTopLevelCMS()
-			} else {
-				listCMS(path, 0)
-			}
+			err = listCMSArguments(arguments)
 		}
+		exitOnError(err)
 
 	} else if arguments["download"] == true {
 		// Download path as filename.zip
-		configLocation, _ := arguments["--config"].(string)
 		var err error
-		config, err = control.InitializeConfiguration(configLocation, debug)
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
+		config, err = initConfig(arguments)
+		exitOnError(err)
 
 		path, _ := arguments["--path"].(string)
 		outputFilename := arguments["<filename>"].(string)
 		if !strings.HasSuffix(outputFilename, ".zip") {
 			outputFilename += ".zip"
 		}
-		download(path, outputFilename)
+		exitOnError(download(path, outputFilename))
 
 	} else if arguments["reset"] == true {
-		configLocation, _ := arguments["--config"].(string)
-		config, err := control.InitializeConfiguration(configLocation, debug)
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
+		var err error
+		config, err = initConfig(arguments)
+		exitOnError(err)
 
 		// config file
 		theme := config.Theme
@@ -454,34 +544,56 @@ Options:
 			theme = "default"
 		}
 		// override from cmdline
-		theme, _ = arguments["<theme>"].(string)
+		if t, ok := arguments["<theme>"].(string); ok {
+			theme = t
+		}
 
-		err = resetCM(config, theme)
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+		profile, _ := arguments["--profile"].(string)
+		only, _ := arguments["--only"].(string)
+		except, _ := arguments["--except"].(string)
+		paths, err := resetTargets(profile, theme, splitList(only), splitList(except))
+		exitOnError(err)
+		yes, _ := arguments["--yes"].(bool)
+		exitOnError(confirmReset(paths, yes))
+
+		if arguments["--no-backup"] != true {
+			exitOnError(backupBeforeReset(theme, paths))
 		}
-		rebuildStyles(config, theme)
+		exitOnError(resetCM(theme, paths))
+
+	} else if arguments["backup"] == true {
+		var err error
+		config, err = initConfig(arguments)
+		exitOnError(err)
+		list, _ := arguments["--paths"].(string)
+		archive, _ := arguments["<archive>"].(string)
+		exitOnError(cmsBackup(splitList(list), archive))
+
+	} else if arguments["restore"] == true {
+		var err error
+		config, err = initConfig(arguments)
+		exitOnError(err)
+		archive, _ := arguments["<archive>"].(string)
+		exitOnError(cmsRestore(archive))
 
 	} else if arguments["users"] == true {
-		configLocation, _ := arguments["--config"].(string)
 		var err error
-		config, err = control.InitializeConfiguration(configLocation, debug)
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
+		config, err = initConfig(arguments)
+		exitOnError(err)
 		userlist, _ := arguments["<userlist>"].(string)
 		if len(userlist) == 0 {
 			fmt.Println("Userlist must exist and may be comma separated")
 			os.Exit(1)
 		}
 		userarray := strings.Split(userlist, ",")
-		err = users.DeleteUserList(userarray, config, debug)
-		if err != nil {
-			fmt.Println(err.Error())
-			os.Exit(1)
-		}
+		exitOnError(users.DeleteUserList(userarray, config, debug))
+
+	} else if arguments["logout"] == true {
+		var err error
+		config, err = initConfig(arguments)
+		exitOnError(err)
+		exitOnError(control.Logout(config, debug))
+		fmt.Printf("Logged out of %s\n", config.URL)
 
 	} else if arguments["version"] == true {
 		// Version
@@ -491,193 +603,129 @@ Options:
 
 }
 
-// listTopLevelCMS is a convenience method to call listCMS for /content and /resources
-func listTopLevelCMS() {
-	listCMS("/content", 0)
-	listCMS("/resources", 0)
-}
-
-func getCMSPath(path string) (cm.ApisResponse, error) {
-
-	var cms cm.ApisResponse
-
-	if debug {
-		log.Println("Getting content of: ", path)
-	}
-	// GET content path
-	client, _, err := control.LoginToCM(config, debug)
+// initConfig loads the configuration chosen by the --config and --env flags
+func initConfig(arguments map[string]interface{}) (control.Configuration, error) {
+	configLocation, _ := arguments["--config"].(string)
+	env, _ := arguments["--env"].(string)
+	config, err := control.InitializeConfiguration(configLocation, env, debug)
 	if err != nil {
-		log.Fatalln(err)
-		return cms, err
+		return config, err
 	}
-
-	url := config.URL + path
-
-	req, err := http.NewRequest("GET", url, nil)
-	req.Header.Add("Accept", "application/json")
-
-	resp, err := client.Do(req)
-
-	defer resp.Body.Close()
-
-	bodyBytes, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return cms, err
+	// command-line flags override the config file
+	if timeout, ok := arguments["--timeout"].(string); ok {
+		config.Timeout = timeout
 	}
-	if debug {
-		log.Printf("%s", bodyBytes)
+	config.RecordDir, _ = arguments["--record"].(string)
+	config.ReplayDir, _ = arguments["--replay"].(string)
+	if config.RecordDir != "" && config.ReplayDir != "" {
+		return config, errors.New("--record and --replay can't be used together")
 	}
-
-	err = json.Unmarshal(bodyBytes, &cms)
-	if err != nil {
-		return cms, err
+	config.DryRun, _ = arguments["--dry-run"].(bool)
+	config.Quiet, _ = arguments["--quiet"].(bool)
+	if limit, ok := arguments["--limit"].(string); ok {
+		if config.Limit, err = strconv.Atoi(limit); err != nil || config.Limit < 0 {
+			return config, fmt.Errorf("Invalid --limit %q", limit)
+		}
 	}
-
-	return cms, nil
+	if pageSize, ok := arguments["--page-size"].(string); ok {
+		if config.PageSize, err = strconv.Atoi(pageSize); err != nil || config.PageSize <= 0 {
+			return config, fmt.Errorf("Invalid --page-size %q", pageSize)
+		}
+	}
+	return config, nil
 }
 
-func listCMS(path string, depth int) (int, int, error) {
-
-	var dircount, filecount int
-
-	if depth == 0 {
-		fmt.Println(path)
-	}
+// initOutput sets the output format from the --output and --template flags
+func initOutput(arguments map[string]interface{}) error {
+	var err error
+	formatName, _ := arguments["--output"].(string)
+	tmpl, _ := arguments["--template"].(string)
+	format, err = output.NewOptions(formatName, tmpl)
+	return err
+}
 
-	cms, err := getCMSPath(path)
+// listProfiles outputs the profiles in a profiles file, marking the current one
+func listProfiles(location string) error {
+	profiles, err := control.LoadProfiles(location)
 	if err != nil {
-		//log.Println("An error in getting path: ", err)
-		// TODO determine what to do with an error that happens with "/content/api"
-		return dircount, filecount, err
+		return err
 	}
-
-	// Output content path
-	var sep string
-
-	var partialbuffer bytes.Buffer
-
-	for k, v := range cms.Channel.Items {
-		// separator determination
-		sep = "├──"
-		if k == len(cms.Channel.Items)-1 {
-			sep = "└──"
-		}
-		// indent determination
-		var indent string
-		if depth > 0 {
-			indent = " "
-			for i := 0; i < depth; i++ {
-				indent = indent + indent
-			}
-			sep = fmt.Sprintf("|%s%s", indent, sep)
-		}
-
-		partialbuffer.WriteString(fmt.Sprintf("%s %s\n", sep, v.Title))
-		fmt.Printf("%s %s\n", sep, v.Title)
-
-		if v.Category[0].Value == "folder" {
-			dircount++
-
-			descend := path + "/" + v.Title
-			depth++
-			d, f, err := listCMS(descend, depth)
-			if err != nil {
-				// eat the error for rendering purposes
-				//log.Printf("Unable to retrieve content of path %s. %s", path, err)
-				//return dircount, filecount, err
-			}
-			dircount = dircount + d
-			filecount = filecount + f
-			depth--
-
-		} else {
-			filecount++
+	var data []string
+	data = append(data, " | Name | URL | Email")
+	for _, name := range profiles.Names() {
+		var current string
+		if name == profiles.Current {
+			current = "*"
 		}
+		p := profiles.Profiles[name]
+		data = append(data, fmt.Sprintf("%s | %s | %s | %s", current, name, p.URL, p.Email))
 	}
-	//fmt.Print(partialbuffer.String())
-
-	if depth == 0 {
-		fmt.Printf("\n%v directories, %v files\n", dircount, filecount)
-	}
-
-	return dircount, filecount, nil
+	fmt.Println(columnize.SimpleFormat(data))
+	return nil
 }
 
-// resetCM deletes an array of items in a CM
-// content or resource directory
-func resetCM(config control.Configuration, theme string) error {
-
-	client, _, err := control.LoginToCM(config, debug)
+// showProfile outputs a profile, or the current one if name is blank, without its password
+func showProfile(location string, name string) error {
+	profiles, err := control.LoadProfiles(location)
 	if err != nil {
-		log.Fatalln(err)
 		return err
 	}
-
-	urls := []string{
-		CMLandingIndex,
-		"/resources/theme/" + theme + CMInternationalization,
-		"/resources/theme/" + theme + CMCustomLess,
-		"/resources/theme/" + theme + CMFavicon,
-		"/resources/theme/" + theme + "/SOA",
-		"/resources/theme/" + theme + "/less",
-		"/resources/theme/" + theme + "/style",
+	if name == "" {
+		name = profiles.Current
 	}
-
-	for _, url := range urls {
-		urlStr := config.URL + url
-		log.Println("Deleting", url)
-		err := callDeleteURL(client, urlStr)
-		if err != nil {
-			return err
-		}
+	config, err := profiles.Select(name)
+	if err != nil {
+		return err
 	}
-
+	b, err := json.MarshalIndent(config.Redacted(), "", "    ")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\n%s\n", name, b)
 	return nil
 }
 
-// Used by resetCM, this is called multiple times to delete
-// a specific url
-func callDeleteURL(client *http.Client, urlStr string) error {
-
-	//client := &http.Client{}
-	//client.Jar = jar
-	req, err := http.NewRequest("DELETE", urlStr, nil)
-	control.AddCsrfHeader(req, client)
-	resp, err := client.Do(req)
-	defer resp.Body.Close()
-	//bodyBytes, err := ioutil.ReadAll(resp.Body)
+func getCMSPath(path string) (cm.ApisResponse, error) {
+
+	var cms cm.ApisResponse
+
+	if debug {
+		log.Println("Getting content of: ", path)
+	}
+	// GET content path
+	client, err := connect()
 	if err != nil {
-		return err
+		return cms, err
 	}
 
-	if debug {
-		if resp.StatusCode != 200 {
-			log.Println("Delete:", resp.Status)
-		}
+	err = client.Get(cmsURI(path), &cms)
+	if err != nil {
+		return cms, err
 	}
 
-	return nil
+	return cms, nil
 }
 
-// curlThis takes an http.Client and http.Request and outputs the
-// equivalent cURL command, to be used elsewhere.
-func curlThis(client *http.Client, req *http.Request) string {
-	curl := "curl -v"
-	for _, v := range client.Jar.Cookies(req.URL) {
-		if strings.HasPrefix(v.Name, "Csrf-Token") {
-			curl += fmt.Sprintf(" -H \"X-%s: %s\"", v.Name, v.Value)
-		} else {
-			curl += fmt.Sprintf(" --cookie \"%s\"", v)
-		}
+// callDeleteURL deletes a CMS path, reporting whether it was there to
+// delete. A path that doesn't exist isn't an error.
+func callDeleteURL(client *control.Client, path string) (bool, error) {
+
+	err := client.Delete(cmsURI(path), nil)
+	var fault *cm.FaultError
+	if !errors.As(err, &fault) {
+		return err == nil, err
 	}
-	for k, v := range req.Header {
-		for _, hv := range v {
-			curl += fmt.Sprintf(" -H \"%s:%v\"", k, hv)
-		}
+	if debug {
+		log.Println("Delete:", fault.Status)
 	}
-	curl += fmt.Sprintf(" %s", req.URL)
-
-	return curl
+	if fault.NotFound() {
+		return false, nil
+	}
+	// CM may fail to delete a path that isn't there, rather than answer not found
+	if _, statErr := statCMS(path); errors.Is(statErr, errNotInCMS) {
+		return false, nil
+	}
+	return false, err
 }
 
 // May not work with 8.0, /api/apps removed?
@@ -686,39 +734,24 @@ func listApps() error {
 		log.Println("Listing Apps")
 	}
 
-	client, userinfo, err := control.LoginToCM(config, debug)
+	client, err := connect()
 	if err != nil {
-		log.Fatalln(err)
 		return err
 	}
 
-	url := config.URL + CMListAppsURI
-
-	req, err := http.NewRequest("GET", url, nil)
-	req.Header.Add("Accept", "application/json")
-
-	resp, err := client.Do(req)
-
-	defer resp.Body.Close()
-
-	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	apps, total, err := client.GetAll(CMListAppsURI)
 	if err != nil {
 		return err
 	}
 	if debug {
-		log.Printf("%s", bodyBytes)
-	}
-	var apps cm.ApisResponse
-	err = json.Unmarshal(bodyBytes, &apps)
-	if debug {
-		log.Printf("Found %v Apps", len(apps.Channel.Items))
+		log.Printf("Found %v Apps", len(apps))
 	}
 
 	var appList Apps
 
-	domainsuffix := strings.Split(userinfo.LoginDomainID, ".")[1]
+	domainsuffix := client.Tenant()
 
-	for _, v := range apps.Channel.Items {
+	for _, v := range apps {
 		var visibility string
 		cats := v.Category
 		for _, c := range cats {
@@ -731,7 +764,7 @@ func listApps() error {
 			visibility = "Registered Users"
 		}
 		// Remove domain suffix from App GUID
-		appguid := strings.Replace(v.Guid.Value, "."+domainsuffix, "", -1)
+		appguid := cm.ShortGUID(v.Guid.Value, domainsuffix)
 
 		appList = append(appList, App{
 			Name:        v.Title,
@@ -743,15 +776,15 @@ func listApps() error {
 		})
 	}
 	sort.Sort(appList)
-	fmt.Printf("%v apps (suffix: %s)\n", len(appList), domainsuffix)
-	// TODO get max length of []App fields and dynamically set the format pattern
-	pattern := "%-36s %-20s %-8s %-3v %-3v %-3v\n"
-	fmt.Printf(pattern, "ID", "Name", "Vis", "Con", "Fol", "Rat")
-	for _, v := range appList {
-		fmt.Printf(pattern, v.ID, v.Name, v.Visibility, v.Connections, v.Followers, v.Rating)
-	}
-
-	return nil
+	return output.Render(appList, format, func() {
+		fmt.Printf("%s (suffix: %s)\n", output.Count(len(appList), total, "apps"), domainsuffix)
+		// TODO get max length of []App fields and dynamically set the format pattern
+		pattern := "%-36s %-20s %-8s %-3v %-3v %-3v\n"
+		fmt.Printf(pattern, "ID", "Name", "Vis", "Con", "Fol", "Rat")
+		for _, v := range appList {
+			fmt.Printf(pattern, v.ID, v.Name, v.Visibility, v.Connections, v.Followers, v.Rating)
+		}
+	})
 }
 
 func listUsers() error {
@@ -759,76 +792,50 @@ func listUsers() error {
 		log.Println("Listing Users")
 	}
 
-	client, _, err := control.LoginToCM(config, debug)
+	client, err := connect()
 	if err != nil {
 		return err
 	}
 
-	url := config.URL + CMListUsersURI
-
-	req, err := http.NewRequest("GET", url, nil)
-	req.Header.Add("Accept", "application/json")
-	if debug {
-		log.Println("curl command:", curlThis(client, req))
-	}
-	resp, err := client.Do(req)
-	defer resp.Body.Close()
-
-	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	items, total, err := client.GetAll(CMListUsersURI)
 	if err != nil {
 		return err
 	}
-
-	var apis cm.ApisResponse
-	err = json.Unmarshal(bodyBytes, &apis)
 	if debug {
-		log.Printf("Found %v Users", len(apis.Channel.Items))
-		fmt.Printf("%s", bodyBytes)
+		log.Printf("Found %v Users", len(items))
 	}
 	var userList Users
 
-	for _, v := range apis.Channel.Items {
+	for _, v := range items {
 		userList = append(userList, User{
 			ProfileName: v.Title, Name: v.Description, Domain: v.Domain, ID: v.Guid.Value,
 			UserName: v.UserName, Email: v.Email,
 		})
 	}
 	sort.Sort(userList)
-	fmt.Printf("%v Users\n", len(userList))
-	var data []string
-	data = append(data, "Name | Email | UserName | Domain | ID")
-	for _, v := range userList {
-		data = append(data, fmt.Sprintf("%s | %s | %s | %s | %s", v.Name, v.Email, v.UserName, v.Domain, v.ID))
-		//fmt.Printf("%-28s %-29s %s @ %s\n", v.Name, v.Email, v.UserName, v.Domain)
-	}
-	result := columnize.SimpleFormat(data)
-	fmt.Println(result)
-	//fmt.Printf("%s", bodyBytes)
-
-	return nil
+	return output.Render(userList, format, func() {
+		fmt.Println(output.Count(len(userList), total, "Users"))
+		var data []string
+		data = append(data, "Name | Email | UserName | Domain | ID")
+		for _, v := range userList {
+			data = append(data, fmt.Sprintf("%s | %s | %s | %s | %s", v.Name, v.Email, v.UserName, v.Domain, v.ID))
+			//fmt.Printf("%-28s %-29s %s @ %s\n", v.Name, v.Email, v.UserName, v.Domain)
+		}
+		result := columnize.SimpleFormat(data)
+		fmt.Println(result)
+	})
 }
 
 func listTopApis() error {
 	log.Println("Listing Top APIs")
 
-	client, _, err := control.LoginToCM(config, debug)
+	client, err := connect()
 	if err != nil {
-		log.Fatalln(err)
 		return err
 	}
 
-	url := config.URL + "/api/businesses/tenantbusiness.enterpriseapi/metrics?TimeInterval=15m&Duration=all&Environment=All&ReportType=business.top10.apis"
-
-	req, err := http.NewRequest("GET", url, nil)
-	req.Header.Add("Accept", "application/json")
-	if debug {
-		log.Println("curl command: ", curlThis(client, req))
-	}
-	resp, err := client.Do(req)
-
-	defer resp.Body.Close()
-
-	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	var bodyBytes []byte
+	err = client.Get("/api/businesses/tenantbusiness.enterpriseapi/metrics?TimeInterval=15m&Duration=all&Environment=All&ReportType=business.top10.apis", &bodyBytes)
 	if err != nil {
 		return err
 	}
@@ -837,145 +844,49 @@ func listTopApis() error {
 }
 
 // Convenience method
-// TODO review this - http client created, but not used?
-func uploadLessFile(uploadFilePath string, config control.Configuration) {
+func uploadLessFile(uploadFilePath string, config control.Configuration) error {
 	log.Printf("Uploading Less file %s to %s\n", uploadFilePath, config.URL)
 
-	client, _, err := control.LoginToCM(config, debug)
+	client, err := connect()
 	if err != nil {
-		log.Fatalln(err)
-		return
+		return err
 	}
 
 	// Upload
 	log.Println("Uploading custom.less ...")
-	extraParams := map[string]string{
-		"none": "really",
-	}
-	uploadURI := config.URL + CMCustomLessURI
+	uploadURI := CMCustomLessURI
 	if config.Theme != "" {
-		uploadURI = config.URL + "/resources/theme/" + config.Theme + "/less?unpack=false"
+		uploadURI = "/resources/theme/" + config.Theme + "/less?unpack=false"
 	}
 
-	statusCode, err := uploadFile(client, uploadFilePath, extraParams, uploadURI)
+	err = uploadFile(client, uploadFilePath, uploadURI)
 	if err != nil {
-		log.Fatalf("Issues. %v : %s", statusCode, err)
-	}
-
-	if statusCode != 200 || debug {
-		log.Printf("Upload status %v", statusCode)
+		return err
 	}
 
-	if statusCode == 200 {
-		err = rebuildStyles(config, config.Theme)
-		if err != nil {
-			log.Fatalln(err)
-		}
-	}
+	return rebuildStyles(config, config.Theme)
 }
 
-func uploadFile(client *http.Client, uploadFilePath string, extras map[string]string, uploadURI string) (int, error) {
-	var uploadStatus int
-
-	//var request *http.Request
-	request, err := newFileUploadRequest(uploadURI, extras, "File", uploadFilePath)
-	if err != nil {
-		log.Fatalln(err)
-		return uploadStatus, err
-	}
-	request.Header.Add("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
-	control.AddCsrfHeader(request, client)
-
-	// debug
+// uploadFile uploads a local file to a CMS path, uploadURI may include query parameters
+func uploadFile(client *control.Client, uploadFilePath string, uploadURI string) error {
 	if debug {
 		log.Println("* URL", uploadURI)
-		log.Println("* Upload Path", uploadFilePath)
-		for k, v := range request.Header {
-			log.Printf("* %s: %s", k, v)
-		}
-	}
-
-	resp, err := client.Do(request)
-	if err != nil {
-		log.Fatal(err)
-		return uploadStatus, err
-	}
-
-	body := &bytes.Buffer{}
-	_, err = body.ReadFrom(resp.Body)
-	if err != nil {
-		log.Fatalln(err)
-	}
-	resp.Body.Close()
-
-	uploadStatus = resp.StatusCode
-
-	if uploadStatus != 200 {
-		b, _ := ioutil.ReadAll(body)
-		log.Println("* uploadFile", string(b))
 	}
-
-	//log.Printf("Upload status %v", resp.StatusCode)
-
-	return uploadStatus, nil
-}
-
-func newFileUploadRequest(uri string, params map[string]string, paramName string, path string) (*http.Request, error) {
-
-	file, err := os.Open(path)
+	err := client.Upload(uploadURI, "File", uploadFilePath, nil)
 	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
-
-	part, err := writer.CreateFormFile(paramName, filepath.Base(path))
-	if err != nil {
-		return nil, err
-	}
-
-	_, err = io.Copy(part, file)
-	if err != nil {
-		log.Fatalln(err)
-		return nil, err
+		return err
 	}
-
-	/*
-		// for any extra params, map of string keys and vals
-			for key, val := range params {
-				_ = writer.WriteField(key, val)
-			}
-	*/
-
-	err = writer.Close()
-	if err != nil {
-		return nil, err
+	if debug {
+		log.Printf("Uploaded %s", uploadFilePath)
 	}
-
-	req, _ := http.NewRequest("POST", uri, body)
-	req.Header.Add("Content-Type", writer.FormDataContentType())
-
-	return req, nil
-}
-
-// Convenience method
-// TODO
-// upload PREFIX_resourcesThemeDefault.zip to CMS /resources/theme/default
-// upload PREFIX_contentHomeLanding.zip to CMS /content/home/landing
-// upload less file to CMS ??
-// call rebuild styles API
-func uploadAllHelper(dir string, config control.Configuration) {
-	fmt.Printf("Uploading all in %s to %s\n", dir, config.URL)
+	return nil
 }
 
 // Call CM Rebuild Styles
 func rebuildStyles(config control.Configuration, theme string) error {
 
-	client, _, err := control.LoginToCM(config, debug)
+	client, err := connect()
 	if err != nil {
-		log.Fatalln(err)
 		return err
 	}
 	// call rebuild styles API
@@ -986,114 +897,102 @@ func rebuildStyles(config control.Configuration, theme string) error {
 		theme = "default"
 	}
 	log.Printf("Rebuilding styles for theme %s ...\n", theme)
-	rebuildStylesURI := config.URL + "/resources/branding/generatestyles"
+	rebuildStylesURI := "/resources/branding/generatestyles"
 	postdata := url.Values{}
 	postdata.Set("theme", theme)
 
-	req, _ := http.NewRequest("POST", rebuildStylesURI, bytes.NewBufferString(postdata.Encode()))
-	req.Header.Add("Content-Length", strconv.Itoa(len(postdata.Encode())))
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	control.AddCsrfHeader(req, client)
-	resp, err := client.Do(req)
-
-	data, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.Fatalln(err)
-		return err
+	var results map[string]interface{}
+	err = client.Post(rebuildStylesURI, "application/x-www-form-urlencoded", []byte(postdata.Encode()), &results)
+	var fault *cm.FaultError
+	if errors.As(err, &fault) && !fault.Unauthorized() && !fault.NotFound() {
+		// CM faults when the theme's less files don't compile
+		return fmt.Errorf("Unable to compile the less files of theme %s: %w", theme, err)
 	}
-	if resp.StatusCode != 200 {
-		if debug {
-			log.Println(resp.StatusCode, resp.Status)
-		}
-		return errors.New("Unable to parse less file. " + resp.Status + " when calling " + rebuildStylesURI)
+	if err != nil {
+		return fmt.Errorf("Unable to rebuild styles for theme %s: %w", theme, err)
 	}
 
-	var results map[string]interface{}
-	err = json.Unmarshal(data, &results)
 	status := results["result"]
 	log.Printf("Rebuild styles: %s", status)
 	return nil
 }
 
 // Download a CMS path to file
-func download(path string, outputFilename string) {
+func download(path string, outputFilename string) error {
 	fmt.Printf("Downloading CMS path %s to file %s\n", path, outputFilename)
 
-	client, _, err := control.LoginToCM(config, debug)
+	client, err := connect()
 	if err != nil {
-		log.Fatalln(err)
-		return
+		return err
 	}
 
-	downloadURI := config.URL + path + "?download=true&Zip=true"
-
 	file, err := os.Create(outputFilename)
 	if err != nil {
-		log.Fatalln(err)
-		return
+		return err
 	}
 	defer file.Close()
 
-	/*
-		check := http.Client{
-			CheckRedirect: func(r *http.Request, via []*http.Request) error {
-				r.URL.Opaque = r.URL.Path
-				return nil
-			},
-		}
-	*/
-	resp, err := client.Get(downloadURI)
+	size, err := client.Download(path+"?download=true&Zip=true", file)
 	if err != nil {
-		log.Fatalln(err)
-		return
-	}
-	if resp.StatusCode != 200 {
-		log.Fatalln(resp.StatusCode, "Unauthorized access to", downloadURI)
-		return
-	}
-	defer resp.Body.Close()
-	log.Println(resp.Status)
-
-	size, err := io.Copy(file, resp.Body)
-	if err != nil {
-		log.Fatalln(err)
-		return
+		return err
 	}
 	log.Printf("%s with %v bytes downloaded.", outputFilename, size)
+	return nil
 }
 
-// basic upload to CMS
-func upload(files []string, config control.Configuration, path string) {
-	log.Printf("Uploading to %s cms location %s these: %s\n", config.URL, path, files)
-	// upload FILE to CMS path PATH
-	// iterate through []FILE
-
-	client, _, err := control.LoginToCM(config, debug)
-	if err != nil {
-		log.Fatalln(err)
-		return
+// listCMSArguments lists the CMS tree of <path>, or of /content and
+// /resources, as limited by --depth and --dirs-only
+func listCMSArguments(arguments map[string]interface{}) error {
+	paths := []string{"/content", "/resources"}
+	if path, _ := arguments["<path>"].(string); path != "" {
+		paths = []string{path}
 	}
+	var depth int
+	if d, ok := arguments["--depth"].(string); ok {
+		var err error
+		depth, err = strconv.Atoi(d)
+		if err != nil || depth < 1 {
+			return fmt.Errorf("--depth must be a number of levels, 1 or more, not %q", d)
+		}
+	}
+	dirsOnly, _ := arguments["--dirs-only"].(bool)
+	return listCMS(paths, depth, dirsOnly)
+}
 
-	uploadURI := config.URL + path
-
-	extraParams := map[string]string{
-		"none": "really",
+// connect returns the logged-in CM client, logging in on first use
+func connect() (*control.Client, error) {
+	if client != nil {
+		return client, nil
 	}
+	var err error
+	client, err = control.NewClient(config, debug)
+	return client, err
+}
 
-	for _, v := range files {
-		if debug {
-			log.Printf("Uploading %s ...\n", v)
-		}
-		if strings.HasSuffix(v, ".zip") {
-			uploadURI += "?unpack=true"
-		}
-		//log.Println(uploadURI)
-		statusCode, err := uploadFile(client, v, extraParams, uploadURI)
-		if err != nil {
-			log.Fatalf("Issues. %v : %s", statusCode, err)
-		}
-		if statusCode != 200 || debug {
-			log.Printf("Upload status %v", statusCode)
-		}
+// exitOnError prints err on stderr and exits when it is non-nil. The exit code
+// reflects the kind of failure: 2 for a CM fault, 3 when CM refused the
+// login or access, 4 when the CM resource doesn't exist, and 1 otherwise.
+func exitOnError(err error) {
+	if err == nil {
+		return
 	}
+	fmt.Fprintln(os.Stderr, err)
+	code := 1
+	var login *control.LoginError
+	var fault *cm.FaultError
+	if errors.As(err, &login) {
+		code = 3
+	} else if errors.Is(err, errNotInCMS) {
+		code = 4
+	} else if errors.As(err, &fault) {
+		switch {
+		case fault.Unauthorized():
+			code = 3
+		case fault.NotFound():
+			code = 4
+		default:
+			code = 2
+		}
+	}
+	os.Exit(code)
 }
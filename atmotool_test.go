@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ghchinoy/atmotool/cm"
+	"github.com/ghchinoy/atmotool/cmtest"
+	"github.com/ghchinoy/atmotool/control"
+	"github.com/ghchinoy/atmotool/output"
+)
+
+// newTestCM starts a fake CM and points the commands at it, caching its
+// login in a temporary home
+func newTestCM(t *testing.T) *cmtest.Server {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	s := cmtest.NewServer()
+	config, client = s.Config(), nil
+	format, _ = output.NewOptions("", "")
+	t.Cleanup(func() {
+		s.Close()
+		config, client = control.Configuration{}, nil
+	})
+	return s
+}
+
+// writeFiles writes files, keyed by their / separated path, below dir
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// uploads counts the uploads the fake CM has received
+func uploads(s *cmtest.Server) int {
+	var n int
+	for _, r := range s.Requests() {
+		if strings.HasPrefix(r, "POST /content") || (strings.HasPrefix(r, "POST /resources/") && !strings.HasPrefix(r, "POST /resources/branding/")) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestRebuildStyles(t *testing.T) {
+	s := newTestCM(t)
+	if err := rebuildStyles(config, ""); err != nil {
+		t.Fatal(err)
+	}
+	s.PutFile("/resources/theme/blue/less/custom.less", []byte("a {\n"))
+	err := rebuildStyles(config, "blue")
+	var fault *cm.FaultError
+	if err == nil || !strings.Contains(err.Error(), "compile the less files of theme blue") || !errors.As(err, &fault) {
+		t.Errorf("rebuilding with unbalanced braces = %v, want a compile fault", err)
+	}
+	if got := s.Rebuilds(); !reflect.DeepEqual(got, []string{"default", "blue"}) {
+		t.Errorf("rebuilds = %v", got)
+	}
+}
+
+func TestListUsersJSON(t *testing.T) {
+	s := newTestCM(t)
+	id := s.AddUser("Ada Lovelace", "ada@example.com")
+	var out bytes.Buffer
+	format, _ = output.NewOptions("json", "")
+	format.Out = &out
+
+	if err := listUsers(); err != nil {
+		t.Fatal(err)
+	}
+	var users []map[string]string
+	if err := json.Unmarshal(out.Bytes(), &users); err != nil {
+		t.Fatalf("%v: %s", err, out.String())
+	}
+	want := map[string]string{"name": "Ada Lovelace", "profileName": "Ada Lovelace", "version": "", "id": id, "domain": "Local Domain", "email": "ada@example.com", "userName": "ada@example.com"}
+	if len(users) != 1 || !reflect.DeepEqual(users[0], want) {
+		t.Errorf("users = %v, want [%v]", users, want)
+	}
+}
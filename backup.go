@@ -0,0 +1,406 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ghchinoy/atmotool/control"
+)
+
+// backupManifestName is the name of the manifest in a backup archive
+const backupManifestName = "manifest.json"
+
+// defaultBackupPaths are backed up when no paths are given
+var defaultBackupPaths = []string{"/content", "/resources"}
+
+// backupManifest describes the CMS files in a backup archive, each of which
+// is stored in it at its CMS path without the leading /
+type backupManifest struct {
+	// Source is the URL of the CM backed up
+	Source  string    `json:"source"`
+	Created time.Time `json:"created"`
+	// Paths are the CMS files and folders backed up
+	Paths []string     `json:"paths"`
+	Files []backupFile `json:"files"`
+}
+
+// backupFile is a CMS file in a backup archive
+type backupFile struct {
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	SHA256  string `json:"sha256"`
+	PubDate string `json:"pubDate,omitempty"`
+}
+
+// cmsBackup downloads CMS files and folders, /content and /resources by
+// default, into one zip archive with a manifest. Paths that don't exist are
+// skipped. Each download goes through a temporary file into the archive, so
+// the CMS isn't held in memory.
+func cmsBackup(paths []string, archive string) error {
+	if len(paths) == 0 {
+		paths = defaultBackupPaths
+	}
+	if filepath.Ext(archive) == "" {
+		archive += ".zip"
+	}
+	client, err := connect()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(archive)
+	if err != nil {
+		return err
+	}
+	manifest := backupManifest{Source: config.URL, Created: time.Now().UTC()}
+	b := &backupWriter{zw: zip.NewWriter(f), manifest: &manifest, seen: make(map[string]bool)}
+	err = func() error {
+		for _, p := range paths {
+			p = cleanCMSPath(p)
+			log.Println("Backing up", p)
+			n, err := backupPath(client, b, p)
+			if errors.Is(err, errNotInCMS) {
+				log.Printf("%s doesn't exist, skipped", p)
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("Unable to back up %s: %w", p, err)
+			}
+			if debug {
+				log.Printf("%d files in %s", n, p)
+			}
+			manifest.Paths = append(manifest.Paths, p)
+		}
+
+		sort.Slice(manifest.Files, func(i, j int) bool { return manifest.Files[i].Path < manifest.Files[j].Path })
+		j, err := json.MarshalIndent(manifest, "", "  ")
+		if err != nil {
+			return err
+		}
+		w, err := b.zw.Create(backupManifestName)
+		if err == nil {
+			_, err = w.Write(j)
+		}
+		if err == nil {
+			err = b.zw.Close()
+		}
+		return err
+	}()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(archive)
+		return err
+	}
+	log.Printf("Backed up %d files from %s to %s", len(manifest.Files), strings.Join(manifest.Paths, ", "), archive)
+	return nil
+}
+
+// backupWriter adds CMS files to a backup archive and its manifest
+type backupWriter struct {
+	zw       *zip.Writer
+	manifest *backupManifest
+	// seen are the CMS files added, as paths given can overlap
+	seen map[string]bool
+}
+
+// add copies the content of the CMS file at p into the archive, hashing it
+// for the manifest on the way
+func (b *backupWriter) add(p string, pubDate string, r io.Reader) error {
+	if b.seen[p] {
+		return nil
+	}
+	b.seen[p] = true
+	w, err := b.zw.Create(strings.TrimPrefix(p, "/"))
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(w, h), r)
+	if err != nil {
+		return err
+	}
+	b.manifest.Files = append(b.manifest.Files, backupFile{Path: p, Size: size, SHA256: hex.EncodeToString(h.Sum(nil)), PubDate: pubDate})
+	return nil
+}
+
+// backupPath adds a CMS file, or every file in a CMS folder, to a backup,
+// returning how many files there were
+func backupPath(client *control.Client, b *backupWriter, p string) (int, error) {
+	item, err := statCMS(p)
+	if err != nil {
+		return 0, err
+	}
+	if !isCMSFolder(item) {
+		f, err := downloadTemp(client, cmsURI(p))
+		if err != nil {
+			return 0, err
+		}
+		defer removeTemp(f)
+		return 1, b.add(p, item.PubDate, f)
+	}
+
+	// the listing has the pubDates, the zip the content
+	tree, err := cmsTree(p, 0)
+	if err != nil {
+		return 0, err
+	}
+	if failed := tree.failures(); len(failed) > 0 {
+		return 0, fmt.Errorf("Unable to list %s: %w", failed[0].Path, failed[0].err)
+	}
+	pubDates := make(map[string]string)
+	var dates func(n *cmsNode)
+	dates = func(n *cmsNode) {
+		for _, c := range n.Children {
+			if c.Type == "folder" {
+				dates(c)
+			} else {
+				pubDates[c.Path] = c.PubDate
+			}
+		}
+	}
+	dates(tree)
+
+	f, err := downloadTemp(client, cmsURI(p)+"?download=true&Zip=true")
+	if err != nil {
+		return 0, err
+	}
+	defer removeTemp(f)
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	zr, err := zip.NewReader(f, info.Size())
+	if err != nil {
+		return 0, fmt.Errorf("Unable to read the zip of %s: %s", p, err)
+	}
+	var n int
+	for _, e := range zr.File {
+		if strings.HasSuffix(e.Name, "/") {
+			continue
+		}
+		name, err := zipEntryName(e.Name)
+		if err != nil {
+			return n, fmt.Errorf("Unable to read the zip of %s: %w", p, err)
+		}
+		rc, err := e.Open()
+		if err != nil {
+			return n, err
+		}
+		full := path.Join(p, name)
+		err = b.add(full, pubDates[full], rc)
+		rc.Close()
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// downloadTemp downloads a CMS uri to a temporary file, returned at its start
+func downloadTemp(client *control.Client, uri string) (*os.File, error) {
+	f, err := ioutil.TempFile("", "atmotool-backup")
+	if err != nil {
+		return nil, err
+	}
+	if _, err = client.Download(uri, f); err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		removeTemp(f)
+		return nil, err
+	}
+	return f, nil
+}
+
+func removeTemp(f *os.File) {
+	f.Close()
+	os.Remove(f.Name())
+}
+
+// cmsRestore uploads the files in a backup archive to the CMS paths they were
+// backed up from, after checking them against the manifest. The files of
+// each path backed up are uploaded to it as one zip, unpacked by CM, which
+// also recreates deleted folders; a file backed up on its own is uploaded to
+// its folder.
+func cmsRestore(archive string) error {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	entries := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		entries[f.Name] = f
+	}
+
+	if entries[backupManifestName] == nil {
+		return fmt.Errorf("%s is not a backup, it has no %s", archive, backupManifestName)
+	}
+	b, err := readZipFile(entries[backupManifestName])
+	if err != nil {
+		return err
+	}
+	var manifest backupManifest
+	if err = json.Unmarshal(b, &manifest); err != nil {
+		return fmt.Errorf("Unable to read the manifest of %s: %s", archive, err)
+	}
+	fmt.Printf("Restoring %d files in %s, backed up from %s at %s\n", len(manifest.Files), strings.Join(manifest.Paths, ", "), manifest.Source, manifest.Created.Local().Format(time.RFC1123))
+
+	staging, cleanup, err := stagingDir()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	// the files of each folder uploaded to are copied into its zip, checked
+	// against the manifest as they go
+	targets := make(map[string]*stagedZip)
+	defer func() {
+		for _, z := range targets {
+			z.close()
+		}
+	}()
+	for _, f := range manifest.Files {
+		p := cleanCMSPath(f.Path)
+		if isCMSRoot(p) {
+			return fmt.Errorf("%s can't be restored, it's not below a CMS root", p)
+		}
+		entry := entries[strings.TrimPrefix(p, "/")]
+		if entry == nil {
+			return fmt.Errorf("%s is missing from %s", p, archive)
+		}
+		target, name, ok := restoreTarget(manifest.Paths, p)
+		if !ok {
+			return fmt.Errorf("%s in %s isn't below any of the paths backed up", p, archive)
+		}
+		if targets[target] == nil {
+			z, err := newStagedZip(filepath.Join(staging, fmt.Sprintf("%d.zip", len(targets))))
+			if err != nil {
+				return err
+			}
+			targets[target] = z
+		}
+		size, sum, err := targets[target].add(name, entry)
+		if err != nil {
+			return err
+		}
+		if size != f.Size || sum != f.SHA256 {
+			return fmt.Errorf("%s in %s doesn't match the manifest", p, archive)
+		}
+	}
+
+	client, err := connect()
+	if err != nil {
+		return err
+	}
+	var names []string
+	for target := range targets {
+		names = append(names, target)
+	}
+	sort.Strings(names)
+	for _, target := range names {
+		z := targets[target]
+		if err = z.close(); err != nil {
+			return err
+		}
+		log.Printf("Uploading %d files to %s", z.n, target)
+		if err = uploadFile(client, z.file.Name(), cmsURI(target)+"?unpack=true"); err != nil {
+			return err
+		}
+	}
+	if !config.DryRun {
+		fmt.Printf("Restored %d files\n", len(manifest.Files))
+	}
+	return nil
+}
+
+// restoreTarget returns the CMS folder a backed up file at p is restored to,
+// and its name in the zip uploaded there: the first of the paths backed up
+// that holds it, as backups add a file once, or the folder of a file backed
+// up on its own
+func restoreTarget(paths []string, p string) (string, string, bool) {
+	for _, backedUp := range paths {
+		backedUp = cleanCMSPath(backedUp)
+		if p == backedUp {
+			return path.Dir(p), path.Base(p), true
+		}
+		if strings.HasPrefix(p, backedUp+"/") {
+			return backedUp, strings.TrimPrefix(p, backedUp+"/"), true
+		}
+	}
+	return "", "", false
+}
+
+// stagedZip is a zip of files being restored to a CMS folder
+type stagedZip struct {
+	file   *os.File
+	zw     *zip.Writer
+	n      int
+	closed bool
+}
+
+func newStagedZip(name string) (*stagedZip, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return &stagedZip{file: f, zw: zip.NewWriter(f)}, nil
+}
+
+// add copies a file in the backup archive into the zip as name, returning
+// its size and SHA-256
+func (z *stagedZip) add(name string, entry *zip.File) (int64, string, error) {
+	rc, err := entry.Open()
+	if err != nil {
+		return 0, "", err
+	}
+	defer rc.Close()
+	w, err := z.zw.Create(name)
+	if err != nil {
+		return 0, "", err
+	}
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(w, h), rc)
+	if err != nil {
+		return 0, "", err
+	}
+	z.n++
+	return size, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// close finishes the zip, once
+func (z *stagedZip) close() error {
+	if z.closed {
+		return nil
+	}
+	z.closed = true
+	err := z.zw.Close()
+	if cerr := z.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
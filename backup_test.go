@@ -0,0 +1,205 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBackupRestore(t *testing.T) {
+	s := newTestCM(t)
+	files := map[string]string{
+		"/content/home/landing/index.htm":             "<h1>hi</h1>",
+		"/content/home/landing/img/banner.png":        "\x89PNG\x00\x01",
+		"/resources/theme/default/less/custom.less":   "a { color: red; }",
+		"/resources/theme/default/i18n/messages.json": `{"title": "Portal"}`,
+		"/resources/theme/other/less/custom.less":     "b {}",
+	}
+	for p, content := range files {
+		s.PutFile(p, []byte(content))
+	}
+
+	archive := filepath.Join(t.TempDir(), "backup")
+	// overlapping paths and a missing one
+	err := cmsBackup([]string{"/content", "/content/home/landing/index.htm", "/resources/theme/default", "/content/nothing"}, archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive += ".zip"
+
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest backupManifest
+	for _, f := range zr.File {
+		if f.Name == backupManifestName {
+			b, err := readZipFile(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = json.Unmarshal(b, &manifest); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	zr.Close()
+	if len(manifest.Files) != 4 {
+		t.Fatalf("backed up %d files, want 4: %+v", len(manifest.Files), manifest.Files)
+	}
+	for _, f := range manifest.Files {
+		if int(f.Size) != len(files[f.Path]) || f.SHA256 != sha256Hex([]byte(files[f.Path])) {
+			t.Errorf("manifest entry %+v doesn't match the CMS file", f)
+		}
+		if f.PubDate == "" {
+			t.Errorf("manifest entry for %s has no pubDate", f.Path)
+		}
+	}
+	if len(manifest.Paths) != 3 || manifest.Source != s.URL {
+		t.Errorf("manifest paths %v from %s", manifest.Paths, manifest.Source)
+	}
+
+	c, err := connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"/content/home", "/resources/theme/default/less"} {
+		if _, err := callDeleteURL(c, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := s.File("/content/home/landing/index.htm"); ok {
+		t.Fatal("delete left /content/home/landing/index.htm")
+	}
+
+	before := len(s.Requests())
+	if err = cmsRestore(archive); err != nil {
+		t.Fatal(err)
+	}
+	for p, content := range files {
+		if got, ok := s.File(p); !ok || string(got) != content {
+			t.Errorf("%s after restore = %q, %v, want %q", p, got, ok, content)
+		}
+	}
+	want := []string{"POST /content?unpack=true", "POST /resources/theme/default?unpack=true"}
+	if got := posts(s.Requests()[before:]); !reflect.DeepEqual(got, want) {
+		t.Errorf("restore uploads = %v, want %v", got, want)
+	}
+}
+
+// posts lists the POST requests among requests, other than logins
+func posts(requests []string) []string {
+	var got []string
+	for _, r := range requests {
+		if strings.HasPrefix(r, "POST ") && r != "POST /api/login" {
+			got = append(got, r)
+		}
+	}
+	return got
+}
+
+// TestRestoreToPathsBackedUp uploads each path's files to that path, and a
+// file backed up on its own to its folder, rather than to the CMS root
+func TestRestoreToPathsBackedUp(t *testing.T) {
+	s := newTestCM(t)
+	files := map[string]string{
+		"/content/home/landing/index.htm":           "<h1>hi</h1>",
+		"/content/home/landing/about.htm":           "<h1>about</h1>",
+		"/resources/theme/default/less/custom.less": "a { color: red; }",
+		"/resources/theme/default/less/base/x.less": "b {}",
+	}
+	for p, content := range files {
+		s.PutFile(p, []byte(content))
+	}
+	archive := filepath.Join(t.TempDir(), "landing.zip")
+	if err := cmsBackup([]string{"/content/home/landing/index.htm", "/resources/theme/default/less"}, archive); err != nil {
+		t.Fatal(err)
+	}
+	c, err := connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"/content/home", "/resources/theme/default/less"} {
+		if _, err := callDeleteURL(c, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	before := len(s.Requests())
+	if err = cmsRestore(archive); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"POST /content/home/landing?unpack=true", "POST /resources/theme/default/less?unpack=true"}
+	if got := posts(s.Requests()[before:]); !reflect.DeepEqual(got, want) {
+		t.Errorf("restore uploads = %v, want %v", got, want)
+	}
+	for p, content := range files {
+		got, ok := s.File(p)
+		if p == "/content/home/landing/about.htm" {
+			if ok {
+				t.Errorf("%s wasn't backed up, but was restored", p)
+			}
+			continue
+		}
+		if !ok || string(got) != content {
+			t.Errorf("%s after restore = %q, %v, want %q", p, got, ok, content)
+		}
+	}
+}
+
+func TestRestoreTarget(t *testing.T) {
+	paths := []string{"/content", "/content/home/landing/index.htm", "/resources/theme/default/"}
+	tests := []struct {
+		p      string
+		target string
+		name   string
+		ok     bool
+	}{
+		{"/content/home/landing/index.htm", "/content", "home/landing/index.htm", true},
+		{"/resources/theme/default/less/custom.less", "/resources/theme/default", "less/custom.less", true},
+		{"/resources/theme/defaults/x.css", "", "", false},
+	}
+	for _, tt := range tests {
+		target, name, ok := restoreTarget(paths, tt.p)
+		if target != tt.target || name != tt.name || ok != tt.ok {
+			t.Errorf("restoreTarget(%s) = %s, %s, %v", tt.p, target, name, ok)
+		}
+	}
+	if target, name, _ := restoreTarget([]string{"/content/home/landing/index.htm"}, "/content/home/landing/index.htm"); target != "/content/home/landing" || name != "index.htm" {
+		t.Errorf("restoreTarget of a file backed up on its own = %s, %s", target, name)
+	}
+}
+
+func TestRestoreRefusesMismatch(t *testing.T) {
+	s := newTestCM(t)
+	archive := filepath.Join(t.TempDir(), "tampered.zip")
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	w, _ := zw.Create("content/home/landing/index.htm")
+	w.Write([]byte("<h1>changed</h1>"))
+	manifest := backupManifest{
+		Paths: []string{"/content"},
+		Files: []backupFile{{Path: "/content/home/landing/index.htm", Size: 11, SHA256: sha256Hex([]byte("<h1>hi</h1>"))}},
+	}
+	b, _ := json.Marshal(manifest)
+	w, _ = zw.Create(backupManifestName)
+	w.Write(b)
+	if err = zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	if err = cmsRestore(archive); err == nil {
+		t.Fatal("restore of a file that doesn't match the manifest succeeded")
+	}
+	if n := uploads(s); n != 0 {
+		t.Errorf("restore uploaded %d times before failing", n)
+	}
+}
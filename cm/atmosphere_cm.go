@@ -9,10 +9,14 @@ type ApisResponse struct {
 	FaultMessage string  `json:"faultstring"`
 }
 
-// Channel is the container for items
+// Channel is the container for items. Paged listings, such as search,
+// report the total number of items and where this page starts.
 type Channel struct {
-	Title string `json:"title"`
-	Items []Item `json:"item"`
+	Title        string `json:"title"`
+	TotalResults int    `json:"totalResults,omitempty"`
+	StartIndex   int    `json:"startIndex,omitempty"`
+	ItemsPerPage int    `json:"itemsPerPage,omitempty"`
+	Items        []Item `json:"item"`
 }
 
 // Item is the generic container
@@ -0,0 +1,64 @@
+package cm
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// FaultError is a failed call to Community Manager, either a non-2xx
+// response or a response carrying a faultcode
+type FaultError struct {
+	Method       string
+	URL          string
+	StatusCode   int
+	Status       string
+	FaultCode    string
+	FaultMessage string
+}
+
+// Error implements the error interface
+func (e *FaultError) Error() string {
+	msg := fmt.Sprintf("%s %s: %s", e.Method, e.URL, e.Status)
+	if e.FaultCode != "" {
+		msg += " " + e.FaultCode
+	}
+	if e.FaultMessage != "" {
+		msg += ": " + e.FaultMessage
+	}
+	return msg
+}
+
+// Unauthorized reports whether CM refused the call because of the login
+func (e *FaultError) Unauthorized() bool {
+	return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+}
+
+// NotFound reports whether CM has no such resource
+func (e *FaultError) NotFound() bool {
+	return e.StatusCode == http.StatusNotFound
+}
+
+// NewFaultError returns a FaultError for a response and its body, or nil if
+// the response is a 2xx without a faultcode
+func NewFaultError(resp *http.Response, body []byte) *FaultError {
+	var fault ApisResponse
+	if strings.HasPrefix(strings.TrimSpace(string(body)), "{") {
+		json.Unmarshal(body, &fault)
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 && fault.FaultCode == "" {
+		return nil
+	}
+	e := &FaultError{
+		StatusCode:   resp.StatusCode,
+		Status:       resp.Status,
+		FaultCode:    fault.FaultCode,
+		FaultMessage: fault.FaultMessage,
+	}
+	if resp.Request != nil {
+		e.Method = resp.Request.Method
+		e.URL = resp.Request.URL.String()
+	}
+	return e
+}
@@ -0,0 +1,30 @@
+package cm
+
+import "strings"
+
+// TenantFromLoginDomainID returns the tenant of a login domain ID, ex. acme
+// for tenantbusiness.acme, or "" if the ID doesn't name one
+func TenantFromLoginDomainID(loginDomainID string) string {
+	i := strings.Index(loginDomainID, ".")
+	if i < 0 {
+		return ""
+	}
+	return loginDomainID[i+1:]
+}
+
+// ShortGUID removes the .tenant suffix CM adds to the GUIDs of a tenant's
+// APIs, apps and users, ex. 4b5ba6ff.acme becomes 4b5ba6ff
+func ShortGUID(guid string, tenant string) string {
+	if tenant == "" {
+		return guid
+	}
+	return strings.TrimSuffix(guid, "."+tenant)
+}
+
+// FullGUID adds the .tenant suffix to a GUID that doesn't have it
+func FullGUID(guid string, tenant string) string {
+	if tenant == "" || guid == "" || strings.HasSuffix(guid, "."+tenant) {
+		return guid
+	}
+	return guid + "." + tenant
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ghchinoy/atmotool/cm"
+	"github.com/ghchinoy/atmotool/control"
+	"github.com/ghchinoy/atmotool/diff"
+	"github.com/ghchinoy/atmotool/output"
+)
+
+// cmsDifference is a file that differs between a local directory and a CMS path
+type cmsDifference struct {
+	Path string `json:"path"`
+	// Status is added (only local), removed (only in the CMS) or modified
+	Status string `json:"status"`
+	Local  string `json:"localSHA256,omitempty"`
+	CMS    string `json:"cmsSHA256,omitempty"`
+	// Diff is the unified diff from the CMS file to the local one, for text files
+	Diff string `json:"diff,omitempty"`
+}
+
+// readCMSZip downloads a CMS path as a zip, in memory, and returns its files
+// keyed by their path relative to it. A path that doesn't exist has no files.
+func readCMSZip(client *control.Client, cmsPath string) (map[string][]byte, error) {
+	var buf bytes.Buffer
+	_, err := client.Download(cmsURI(cmsPath)+"?download=true&Zip=true", &buf)
+	var fault *cm.FaultError
+	if errors.As(err, &fault) && fault.NotFound() {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read the zip of %s: %s", cmsPath, err)
+	}
+	files := make(map[string][]byte)
+	for _, f := range zr.File {
+		if strings.HasSuffix(f.Name, "/") {
+			continue
+		}
+		name, err := zipEntryName(f.Name)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read the zip of %s: %w", cmsPath, err)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		b, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		files[name] = b
+	}
+	return files, nil
+}
+
+// zipEntryName returns the cleaned name of a zip entry, refusing names that
+// are absolute or outside the zipped folder, ex. ../x, which would be
+// written outside where the zip's files are put
+func zipEntryName(name string) (string, error) {
+	clean := path.Clean(name)
+	if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("zip entry %s is outside the folder zipped", name)
+	}
+	return clean, nil
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// isText reports whether content can be shown in a diff, ex. less, html or
+// i18n json, rather than an image
+func isText(b []byte) bool {
+	return utf8.Valid(b) && !bytes.ContainsRune(b, 0)
+}
+
+// cmsDiff compares a local directory with a CMS path, by content, and outputs
+// the files that were added, removed or modified locally, with a unified
+// diff of modified text files
+func cmsDiff(localDir string, cmsPath string) error {
+	cmsPath = cleanCMSPath(cmsPath)
+	files, _, err := walkLocal(localDir)
+	if err != nil {
+		return err
+	}
+	client, err := connect()
+	if err != nil {
+		return err
+	}
+	remote, err := readCMSZip(client, cmsPath)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	for name := range remote {
+		if skippedLocally(name) {
+			// compared like the local walk, so .git or .DS_Store aren't removed
+			delete(remote, name)
+			continue
+		}
+		if files[name] == nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var differences []cmsDifference
+	var unchanged int
+	for _, name := range names {
+		d := cmsDifference{Path: name}
+		var local []byte
+		if files[name] != nil {
+			local, err = ioutil.ReadFile(filepath.Join(localDir, filepath.FromSlash(name)))
+			if err != nil {
+				return err
+			}
+			d.Local = sha256Hex(local)
+		}
+		cmsFile, inCMS := remote[name]
+		if inCMS {
+			d.CMS = sha256Hex(cmsFile)
+		}
+		switch {
+		case !inCMS:
+			d.Status = "added"
+		case files[name] == nil:
+			d.Status = "removed"
+		case d.Local == d.CMS:
+			unchanged++
+			continue
+		default:
+			d.Status = "modified"
+			if isText(local) && isText(cmsFile) {
+				d.Diff = diff.Unified(path.Join(cmsPath, name), filepath.Join(localDir, filepath.FromSlash(name)), string(cmsFile), string(local))
+			}
+		}
+		differences = append(differences, d)
+	}
+
+	return output.Render(differences, format, func() {
+		fmt.Printf("Comparing %s with %s\n", localDir, cmsPath)
+		counts := map[string]int{}
+		for _, d := range differences {
+			counts[d.Status]++
+			fmt.Printf("%-8s %s\n", d.Status, d.Path)
+			if d.Diff != "" {
+				fmt.Print(d.Diff)
+			}
+		}
+		fmt.Printf("%d added, %d removed, %d modified, %d unchanged\n", counts["added"], counts["removed"], counts["modified"], unchanged)
+	})
+}
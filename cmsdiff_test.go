@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/ghchinoy/atmotool/output"
+)
+
+func TestZipEntryName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+		ok   bool
+	}{
+		{"less/custom.less", "less/custom.less", true},
+		{"./style/../style/app.css", "style/app.css", true},
+		{"a/../../x", "", false},
+		{"../x", "", false},
+		{"..", "", false},
+		{"/etc/passwd", "", false},
+		{"..x/y", "..x/y", true},
+	}
+	for _, tt := range tests {
+		got, err := zipEntryName(tt.name)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("zipEntryName(%q) = %q, %v, want %q, ok %v", tt.name, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+// TestCMSDiffSkipsHidden leaves out of both sides what the local walk skips,
+// so hidden folders and excluded files in the CMS aren't reported removed
+func TestCMSDiffSkipsHidden(t *testing.T) {
+	s := newTestCM(t)
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"index.htm":   "<h1>hi</h1>",
+		".git/config": "[core]",
+		".DS_Store":   "local",
+	})
+	for name, content := range map[string]string{
+		"index.htm":     "<h1>hi</h1>",
+		"old.htm":       "<h1>old</h1>",
+		".git/HEAD":     "ref: refs/heads/main",
+		".DS_Store":     "cms",
+		"img/.DS_Store": "cms",
+		"site.zip":      "PK",
+	} {
+		s.PutFile("/content/home/landing/"+name, []byte(content))
+	}
+
+	var out bytes.Buffer
+	format, _ = output.NewOptions("json", "")
+	format.Out = &out
+	if err := cmsDiff(dir, "/content/home/landing"); err != nil {
+		t.Fatal(err)
+	}
+	var differences []cmsDifference
+	if err := json.Unmarshal(out.Bytes(), &differences); err != nil {
+		t.Fatalf("%v: %s", err, out.String())
+	}
+	want := []cmsDifference{{Path: "old.htm", Status: "removed", CMS: sha256Hex([]byte("<h1>old</h1>"))}}
+	if !reflect.DeepEqual(differences, want) {
+		t.Errorf("differences = %+v, want %+v", differences, want)
+	}
+}
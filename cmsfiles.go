@@ -0,0 +1,355 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/ghchinoy/atmotool/cm"
+	"github.com/ghchinoy/atmotool/control"
+)
+
+// errNotInCMS is returned by statCMS for a path that doesn't exist
+var errNotInCMS = errors.New("no such CMS file or folder")
+
+// cleanCMSPath normalizes a CMS path to /content/a/b form
+func cleanCMSPath(p string) string {
+	return path.Clean("/" + strings.Trim(p, "/"))
+}
+
+// cmsURI escapes a CMS path for use in a request, ex. for names with spaces
+func cmsURI(p string) string {
+	return (&url.URL{Path: p}).EscapedPath()
+}
+
+// isCMSRoot reports whether a clean CMS path is a root, such as /content
+func isCMSRoot(p string) bool {
+	return path.Dir(p) == "/"
+}
+
+// statCMS returns the listing item of a CMS file or folder, from the listing
+// of the folder it's in
+func statCMS(p string) (cm.Item, error) {
+	p = cleanCMSPath(p)
+	if isCMSRoot(p) {
+		return cm.Item{Title: path.Base(p), Category: []cm.ValueDomain{{Value: "folder"}}}, nil
+	}
+	listing, err := getCMSPath(path.Dir(p))
+	var fault *cm.FaultError
+	if errors.As(err, &fault) && fault.NotFound() {
+		return cm.Item{}, fmt.Errorf("%s: %w", p, errNotInCMS)
+	}
+	if err != nil {
+		return cm.Item{}, err
+	}
+	for _, v := range listing.Channel.Items {
+		if v.Title == path.Base(p) {
+			return v, nil
+		}
+	}
+	return cm.Item{}, fmt.Errorf("%s: %w", p, errNotInCMS)
+}
+
+// stagingDir creates a temporary directory for files on their way to the
+// CMS, and returns a func removing it. In a dry run it's kept, because the
+// printed curl commands refer to the files in it.
+func stagingDir() (string, func(), error) {
+	dir, err := ioutil.TempDir("", "atmotool")
+	if err != nil {
+		return "", nil, err
+	}
+	if config.DryRun {
+		log.Println("Staged uploads in", dir)
+		return dir, func() {}, nil
+	}
+	return dir, func() { os.RemoveAll(dir) }, nil
+}
+
+// cmsCat writes a CMS file to stdout
+func cmsCat(p string) error {
+	p = cleanCMSPath(p)
+	item, err := statCMS(p)
+	if err != nil {
+		return err
+	}
+	if isCMSFolder(item) {
+		return fmt.Errorf("%s is a folder, see cms list", p)
+	}
+	client, err := connect()
+	if err != nil {
+		return err
+	}
+	_, err = client.Download(cmsURI(p), os.Stdout)
+	return err
+}
+
+// cmsGet downloads a CMS file, or a folder and everything in it, to dest.
+// dest defaults to the current directory; when it's a directory, the file or
+// folder is downloaded into it.
+func cmsGet(p string, dest string) error {
+	p = cleanCMSPath(p)
+	item, err := statCMS(p)
+	if err != nil {
+		return err
+	}
+	if dest == "" {
+		dest = "."
+	}
+	target := dest
+	if info, err := os.Stat(dest); err == nil && info.IsDir() {
+		target = filepath.Join(dest, path.Base(p))
+	}
+
+	client, err := connect()
+	if err != nil {
+		return err
+	}
+	if isCMSFolder(item) {
+		files, err := readCMSZip(client, p)
+		if err != nil {
+			return err
+		}
+		for name, content := range files {
+			local := filepath.Join(target, filepath.FromSlash(name))
+			if err = os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+				return err
+			}
+			if err = ioutil.WriteFile(local, content, 0644); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("Downloaded %d files from %s to %s\n", len(files), p, target)
+		return nil
+	}
+
+	file, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	size, err := client.Download(cmsURI(p), file)
+	if err != nil {
+		file.Close()
+		os.Remove(target)
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("Downloaded %s to %s (%d bytes)\n", p, target, size)
+	return nil
+}
+
+// cmsPut uploads a local file to a CMS path. When the path is a folder, or
+// ends with /, the file keeps its name in it; otherwise the path names the
+// file. Zips are stored as they are, not unpacked.
+func cmsPut(local string, p string) error {
+	info, err := os.Stat(local)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory, see cms sync", local)
+	}
+	folder, name := path.Dir(cleanCMSPath(p)), path.Base(cleanCMSPath(p))
+	if strings.HasSuffix(p, "/") {
+		folder, name = cleanCMSPath(p), filepath.Base(local)
+	} else if item, err := statCMS(p); err == nil && isCMSFolder(item) {
+		folder, name = cleanCMSPath(p), filepath.Base(local)
+	} else if err != nil && !errors.Is(err, errNotInCMS) {
+		return err
+	}
+	if folder == "/" {
+		return fmt.Errorf("Files can't be put at the top of the CMS, use a path below /content or /resources")
+	}
+
+	client, err := connect()
+	if err != nil {
+		return err
+	}
+	if err = uploadAs(client, local, folder, name); err != nil {
+		return err
+	}
+	if !config.DryRun {
+		fmt.Printf("Uploaded %s to %s\n", local, path.Join(folder, name))
+	}
+	return nil
+}
+
+// uploadAs uploads a local file into a CMS folder with the given name,
+// without unpacking zips. CM names an upload after its file, so a file being
+// renamed is copied to that name first.
+func uploadAs(client *control.Client, local string, folder string, name string) error {
+	if filepath.Base(local) != name {
+		staging, cleanup, err := stagingDir()
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		renamed := filepath.Join(staging, name)
+		if err = copyFile(local, renamed); err != nil {
+			return err
+		}
+		local = renamed
+	}
+	return uploadFile(client, local, cmsURI(folder)+"?unpack=false")
+}
+
+// cmsRemove deletes a CMS file or, with recursive, a folder and everything in it
+func cmsRemove(p string, recursive bool) error {
+	p = cleanCMSPath(p)
+	if isCMSRoot(p) {
+		return fmt.Errorf("%s can't be deleted", p)
+	}
+	item, err := statCMS(p)
+	if err != nil {
+		return err
+	}
+	if isCMSFolder(item) && !recursive {
+		return fmt.Errorf("%s is a folder, use -r to delete it and everything in it", p)
+	}
+	client, err := connect()
+	if err != nil {
+		return err
+	}
+	if err = client.Delete(cmsURI(p), nil); err != nil {
+		return err
+	}
+	if !config.DryRun {
+		fmt.Printf("Deleted %s\n", p)
+	}
+	return nil
+}
+
+// cmsMkdir creates a CMS folder, and the folders above it, by unpacking a zip
+// holding only the folder at the CMS root
+func cmsMkdir(p string) error {
+	p = cleanCMSPath(p)
+	if isCMSRoot(p) {
+		return fmt.Errorf("%s is a CMS root", p)
+	}
+	parts := strings.SplitN(strings.TrimPrefix(p, "/"), "/", 2)
+	root, rel := "/"+parts[0], parts[1]
+
+	staging, cleanup, err := stagingDir()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	archive := filepath.Join(staging, path.Base(p)+".zip")
+	f, err := os.Create(archive)
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(f)
+	if _, err = zw.Create(rel + "/"); err != nil {
+		f.Close()
+		return err
+	}
+	if err = zw.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+
+	client, err := connect()
+	if err != nil {
+		return err
+	}
+	if err = uploadFile(client, archive, cmsURI(root)+"?unpack=true"); err != nil {
+		return err
+	}
+	if !config.DryRun {
+		fmt.Printf("Created %s\n", p)
+	}
+	return nil
+}
+
+// cmsCopy copies a CMS file or folder to dest by downloading and uploading
+// it, and with move, deletes the original. As with cp and mv, when dest is a
+// folder, or ends with /, the copy is made in it.
+func cmsCopy(src string, dest string, move bool) error {
+	src = cleanCMSPath(src)
+	if isCMSRoot(src) {
+		return fmt.Errorf("%s can't be copied or moved", src)
+	}
+	item, err := statCMS(src)
+	if err != nil {
+		return err
+	}
+	target := cleanCMSPath(dest)
+	if strings.HasSuffix(dest, "/") {
+		target = path.Join(target, path.Base(src))
+	} else if t, err := statCMS(target); err == nil && isCMSFolder(t) {
+		target = path.Join(target, path.Base(src))
+	} else if err != nil && !errors.Is(err, errNotInCMS) {
+		return err
+	}
+	if target == src || strings.HasPrefix(target, src+"/") {
+		return fmt.Errorf("%s can't be copied onto or into itself", src)
+	}
+	if isCMSRoot(target) {
+		return fmt.Errorf("%s is not below /content or /resources", target)
+	}
+
+	client, err := connect()
+	if err != nil {
+		return err
+	}
+	staging, cleanup, err := stagingDir()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if isCMSFolder(item) {
+		// the folder's zip unpacks into the target folder
+		archive := filepath.Join(staging, path.Base(target)+".zip")
+		if err = downloadTo(client, cmsURI(src)+"?download=true&Zip=true", archive); err != nil {
+			return err
+		}
+		err = uploadFile(client, archive, cmsURI(target)+"?unpack=true")
+	} else {
+		local := filepath.Join(staging, path.Base(target))
+		if err = downloadTo(client, cmsURI(src), local); err != nil {
+			return err
+		}
+		err = uploadFile(client, local, cmsURI(path.Dir(target))+"?unpack=false")
+	}
+	if err != nil {
+		return err
+	}
+
+	verb := "Copied"
+	if move {
+		if err = client.Delete(cmsURI(src), nil); err != nil {
+			return fmt.Errorf("Copied %s to %s, but unable to delete it: %w", src, target, err)
+		}
+		verb = "Moved"
+	}
+	if !config.DryRun {
+		fmt.Printf("%s %s to %s\n", verb, src, target)
+	}
+	return nil
+}
+
+// downloadTo downloads a CMS request path to a local file
+func downloadTo(client *control.Client, uri string, local string) error {
+	file, err := os.Create(local)
+	if err != nil {
+		return err
+	}
+	if _, err = client.Download(uri, file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ghchinoy/atmotool/cm"
+	"github.com/ghchinoy/atmotool/control"
+	"github.com/ghchinoy/atmotool/zip"
+)
+
+// cmsEntry is a file or folder found below a CMS path
+type cmsEntry struct {
+	// Path is relative to the walked CMS path, separated by /
+	Path   string
+	Folder bool
+}
+
+// walkCMS returns everything below a CMS path, each folder before its
+// contents. A path that doesn't exist has nothing below it; any folder that
+// can't be listed fails the walk.
+func walkCMS(root string) ([]cmsEntry, error) {
+	tree, err := cmsTree(root, 0)
+	var fault *cm.FaultError
+	if errors.As(err, &fault) && fault.NotFound() {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if failed := tree.failures(); len(failed) > 0 {
+		return nil, fmt.Errorf("Unable to list %s: %w", failed[0].Path, failed[0].err)
+	}
+
+	var entries []cmsEntry
+	var walk func(n *cmsNode, rel string)
+	walk = func(n *cmsNode, rel string) {
+		for _, c := range n.Children {
+			e := cmsEntry{Path: path.Join(rel, c.Name), Folder: c.Type == "folder"}
+			entries = append(entries, e)
+			if e.Folder {
+				walk(c, e.Path)
+			}
+		}
+	}
+	walk(tree, "")
+	return entries, nil
+}
+
+// isCMSFolder reports whether a CMS listing item is a folder
+func isCMSFolder(v cm.Item) bool {
+	for _, c := range v.Category {
+		if c.Value == "folder" {
+			return true
+		}
+	}
+	return false
+}
+
+// walkLocal returns the files below dir, keyed by their / separated path
+// relative to it, and the folders that hold them. Files zip.ZipFolder leaves
+// out, and hidden folders such as .git, are skipped.
+func walkLocal(dir string) (map[string]os.FileInfo, map[string]bool, error) {
+	files := make(map[string]os.FileInfo)
+	folders := make(map[string]bool)
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			return nil
+		}
+		if info.IsDir() {
+			if strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			folders[rel] = true
+			return nil
+		}
+		if info.Mode().IsRegular() && !zip.Excluded(info.Name()) {
+			files[rel] = info
+		}
+		return nil
+	})
+	return files, folders, err
+}
+
+// skippedLocally reports whether walkLocal skips a / separated relative
+// path, as it's below a hidden folder or a file zip.ZipFolder leaves out
+func skippedLocally(name string) bool {
+	parts := strings.Split(name, "/")
+	for _, folder := range parts[:len(parts)-1] {
+		if strings.HasPrefix(folder, ".") {
+			return true
+		}
+	}
+	return zip.Excluded(parts[len(parts)-1])
+}
+
+// syncPlan is what cms sync does to make a CMS path match a local directory
+type syncPlan struct {
+	// added and changed are local files to upload
+	added   []string
+	changed []string
+	// remote are CMS files and folders that aren't in the local directory;
+	// a folder stands for everything below it
+	remote    []string
+	unchanged int
+}
+
+// planSync compares a local directory with the CMS path it's synced to,
+// given the content of the CMS files. A file has changed when its content
+// differs from the CMS copy's, as cms diff compares them; pubDates are only
+// to the second, and by CM's clock.
+func planSync(localDir string, files map[string]os.FileInfo, folders map[string]bool, remote []cmsEntry, cmsFiles map[string][]byte) (syncPlan, error) {
+	var plan syncPlan
+	listed := make(map[string]bool)
+	for _, e := range remote {
+		if !e.Folder {
+			listed[e.Path] = true
+		}
+	}
+
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !listed[name] {
+			plan.added = append(plan.added, name)
+			continue
+		}
+		cmsFile, ok := cmsFiles[name]
+		if !ok {
+			plan.changed = append(plan.changed, name)
+			continue
+		}
+		local, err := ioutil.ReadFile(filepath.Join(localDir, filepath.FromSlash(name)))
+		if err != nil {
+			return plan, err
+		}
+		if sha256Hex(local) != sha256Hex(cmsFile) {
+			plan.changed = append(plan.changed, name)
+		} else {
+			plan.unchanged++
+		}
+	}
+
+	var gone string
+	for _, e := range remote {
+		if gone != "" && strings.HasPrefix(e.Path, gone+"/") {
+			continue
+		}
+		if (e.Folder && !folders[e.Path]) || (!e.Folder && files[e.Path] == nil) {
+			plan.remote = append(plan.remote, e.Path)
+			if e.Folder {
+				gone = e.Path
+			}
+		}
+	}
+	return plan, nil
+}
+
+// print outputs the plan, listing remote-only paths when they're to be deleted
+func (plan syncPlan) print(remove bool, remote []cmsEntry) {
+	folder := make(map[string]bool)
+	for _, e := range remote {
+		folder[e.Path] = e.Folder
+	}
+	for _, name := range plan.added {
+		fmt.Printf("+ %s\n", name)
+	}
+	for _, name := range plan.changed {
+		fmt.Printf("~ %s\n", name)
+	}
+	if remove {
+		for _, name := range plan.remote {
+			if folder[name] {
+				name += "/"
+			}
+			fmt.Printf("- %s\n", name)
+		}
+	}
+
+	summary := fmt.Sprintf("%d new, %d changed, %d unchanged", len(plan.added), len(plan.changed), plan.unchanged)
+	if remove {
+		summary += fmt.Sprintf(", %d to delete", len(plan.remote))
+	} else if len(plan.remote) > 0 {
+		summary += fmt.Sprintf(", %d only in the CMS (use --delete to delete them)", len(plan.remote))
+	}
+	fmt.Println(summary)
+}
+
+// cmsSync uploads the new and changed files of a local directory to a CMS
+// path, after printing what it will do. With remove, CMS files and folders
+// that aren't in the local directory are deleted.
+func cmsSync(localDir string, cmsPath string, remove bool) error {
+	info, err := os.Stat(localDir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", localDir)
+	}
+	cmsPath = cleanCMSPath(cmsPath)
+
+	files, folders, err := walkLocal(localDir)
+	if err != nil {
+		return err
+	}
+	remote, err := walkCMS(cmsPath)
+	if err != nil {
+		return err
+	}
+	client, err := connect()
+	if err != nil {
+		return err
+	}
+	cmsFiles, err := readCMSZip(client, cmsPath)
+	if err != nil {
+		return err
+	}
+	plan, err := planSync(localDir, files, folders, remote, cmsFiles)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Syncing %s to %s\n", localDir, cmsPath)
+	plan.print(remove, remote)
+	uploads := append(append([]string{}, plan.added...), plan.changed...)
+	if len(uploads) == 0 && (!remove || len(plan.remote) == 0) {
+		fmt.Println("Nothing to sync")
+		return nil
+	}
+	if len(uploads) > 0 {
+		if err = uploadBatch(client, localDir, cmsPath, uploads, files); err != nil {
+			return err
+		}
+	}
+	if remove {
+		for _, name := range plan.remote {
+			log.Println("Deleting", path.Join(cmsPath, name))
+			if _, err = callDeleteURL(client, path.Join(cmsPath, name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// uploadBatch uploads local files to the same relative paths below a CMS
+// path, as one zip unpacked by CM. Empty files, which zip.ZipFolder leaves
+// out, are uploaded one by one.
+func uploadBatch(client *control.Client, localDir string, cmsPath string, names []string, files map[string]os.FileInfo) error {
+	staging, cleanup, err := stagingDir()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	src := filepath.Join(staging, "files")
+	var zipped int
+	for _, name := range names {
+		local := filepath.Join(localDir, filepath.FromSlash(name))
+		if files[name].Size() == 0 {
+			log.Println("Uploading", name)
+			if err = uploadFile(client, local, cmsURI(path.Join(cmsPath, path.Dir(name)))+"?unpack=false"); err != nil {
+				return fmt.Errorf("Unable to upload %s: %w", name, err)
+			}
+			continue
+		}
+		if err = copyFile(local, filepath.Join(src, filepath.FromSlash(name))); err != nil {
+			return err
+		}
+		zipped++
+	}
+	if zipped == 0 {
+		return nil
+	}
+
+	archive := filepath.Join(staging, "sync.zip")
+	if err = zip.ZipFolder(src, archive); err != nil {
+		return err
+	}
+	log.Printf("Uploading %d files to %s", zipped, cmsPath)
+	return uploadFile(client, archive, cmsURI(cmsPath)+"?unpack=true")
+}
+
+// copyFile copies the file at src to dst, creating dst's directory
+func copyFile(src string, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
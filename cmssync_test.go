@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCMSSyncAgainIsNoOp(t *testing.T) {
+	s := newTestCM(t)
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"less/custom.less":   "@brand: #336699;\n",
+		"img/logo.svg":       "<svg/>",
+		"i18n/messages.json": `{"title": "Portal"}`,
+	})
+
+	if err := cmsSync(dir, "/resources/theme/default", false); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := s.File("/resources/theme/default/less/custom.less"); !ok || string(got) != "@brand: #336699;\n" {
+		t.Fatalf("custom.less after sync = %q, %v", got, ok)
+	}
+	first := uploads(s)
+
+	// newer than the CMS copies, but with the same content
+	later := time.Now().Add(time.Hour)
+	for _, name := range []string{"less/custom.less", "img/logo.svg", "i18n/messages.json"} {
+		if err := os.Chtimes(filepath.Join(dir, filepath.FromSlash(name)), later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cmsSync(dir, "/resources/theme/default", false); err != nil {
+		t.Fatal(err)
+	}
+	if n := uploads(s) - first; n != 0 {
+		t.Errorf("second sync uploaded %d times, want a no-op", n)
+	}
+
+	// an edit that keeps the file's size and an older mtime is still changed
+	writeFiles(t, dir, map[string]string{"less/custom.less": "@brand: #993366;\n"})
+	earlier := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "less", "custom.less"), earlier, earlier); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmsSync(dir, "/resources/theme/default", false); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.File("/resources/theme/default/less/custom.less"); string(got) != "@brand: #993366;\n" {
+		t.Errorf("custom.less after an edit = %q", got)
+	}
+	if got, _ := s.File("/resources/theme/default/img/logo.svg"); string(got) != "<svg/>" {
+		t.Errorf("logo.svg = %q", got)
+	}
+}
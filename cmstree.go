@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/ghchinoy/atmotool/output"
+)
+
+// cmsListWorkers is the most CMS folders listed at the same time
+const cmsListWorkers = 8
+
+// cmsNode is a CMS file or folder in a tree listing
+type cmsNode struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	// Type is folder or file, from the listing's category
+	Type     string     `json:"type"`
+	PubDate  string     `json:"pubDate,omitempty"`
+	Children []*cmsNode `json:"children,omitempty"`
+	// Error is why a folder couldn't be listed
+	Error string `json:"error,omitempty"`
+	err   error
+}
+
+// cmsRow is a cmsNode without its children, for CSV output
+type cmsRow struct {
+	Path    string `json:"path"`
+	Type    string `json:"type"`
+	PubDate string `json:"pubDate"`
+	Error   string `json:"error"`
+}
+
+// cmsTree lists a CMS path and the folders below it, down to depth levels
+// (0 for all), listing up to cmsListWorkers folders at once. A folder that
+// can't be listed keeps its error rather than failing the whole listing; only
+// an error listing root itself is returned.
+func cmsTree(root string, depth int) (*cmsNode, error) {
+	root = cleanCMSPath(root)
+	// log in before listing concurrently
+	if _, err := connect(); err != nil {
+		return nil, err
+	}
+
+	tree := &cmsNode{Name: root, Path: root, Type: "folder"}
+	sem := make(chan struct{}, cmsListWorkers)
+	var wg sync.WaitGroup
+	var list func(n *cmsNode, level int)
+	list = func(n *cmsNode, level int) {
+		defer wg.Done()
+		sem <- struct{}{}
+		listing, err := getCMSPath(n.Path)
+		<-sem
+		if err != nil {
+			n.err, n.Error = err, err.Error()
+			return
+		}
+		for _, v := range listing.Channel.Items {
+			child := &cmsNode{Name: v.Title, Path: path.Join(n.Path, v.Title), Type: "file", PubDate: v.PubDate}
+			if isCMSFolder(v) {
+				child.Type = "folder"
+			}
+			n.Children = append(n.Children, child)
+		}
+		if depth > 0 && level >= depth {
+			return
+		}
+		for _, c := range n.Children {
+			if c.Type == "folder" {
+				wg.Add(1)
+				go list(c, level+1)
+			}
+		}
+	}
+	wg.Add(1)
+	list(tree, 1)
+	wg.Wait()
+
+	if tree.err != nil {
+		return nil, tree.err
+	}
+	return tree, nil
+}
+
+// failures returns the folders below n that couldn't be listed
+func (n *cmsNode) failures() []*cmsNode {
+	var failed []*cmsNode
+	if n.err != nil {
+		failed = append(failed, n)
+	}
+	for _, c := range n.Children {
+		failed = append(failed, c.failures()...)
+	}
+	return failed
+}
+
+// foldersOnly returns a copy of the tree without its files
+func (n *cmsNode) foldersOnly() *cmsNode {
+	folders := *n
+	folders.Children = nil
+	for _, c := range n.Children {
+		if c.Type == "folder" {
+			folders.Children = append(folders.Children, c.foldersOnly())
+		}
+	}
+	return &folders
+}
+
+// rows returns the files and folders below n, each folder before its contents
+func (n *cmsNode) rows() []cmsRow {
+	var rows []cmsRow
+	for _, c := range n.Children {
+		rows = append(rows, cmsRow{Path: c.Path, Type: c.Type, PubDate: c.PubDate, Error: c.Error})
+		rows = append(rows, c.rows()...)
+	}
+	return rows
+}
+
+// print outputs the files and folders below n, tree style, returning how many
+// folders and files there are
+func (n *cmsNode) print(prefix string) (int, int) {
+	var dirs, files int
+	for i, c := range n.Children {
+		branch, indent := "├── ", "│   "
+		if i == len(n.Children)-1 {
+			branch, indent = "└── ", "    "
+		}
+		name := c.Name
+		if c.err != nil {
+			name += " (unable to list)"
+		}
+		fmt.Printf("%s%s%s\n", prefix, branch, name)
+		if c.Type == "folder" {
+			dirs++
+			d, f := c.print(prefix + indent)
+			dirs, files = dirs+d, files+f
+		} else {
+			files++
+		}
+	}
+	return dirs, files
+}
+
+// listCMS outputs the trees of CMS paths, down to depth levels (0 for all),
+// failing after the output if any folders couldn't be listed
+func listCMS(paths []string, depth int, dirsOnly bool) error {
+	var trees []*cmsNode
+	for _, p := range paths {
+		tree, err := cmsTree(p, depth)
+		if err != nil {
+			return err
+		}
+		if dirsOnly {
+			tree = tree.foldersOnly()
+		}
+		trees = append(trees, tree)
+	}
+
+	var data interface{} = trees
+	if format.Format == output.CSV {
+		var rows []cmsRow
+		for _, tree := range trees {
+			rows = append(rows, tree.rows()...)
+		}
+		data = rows
+	} else if len(trees) == 1 {
+		data = trees[0]
+	}
+	err := output.Render(data, format, func() {
+		for _, tree := range trees {
+			fmt.Println(tree.Path)
+			dirs, files := tree.print("")
+			if dirsOnly {
+				fmt.Printf("\n%v directories\n", dirs)
+			} else {
+				fmt.Printf("\n%v directories, %v files\n", dirs, files)
+			}
+		}
+	})
+
+	var failures int
+	for _, tree := range trees {
+		for _, failed := range tree.failures() {
+			fmt.Fprintf(os.Stderr, "Unable to list %s: %s\n", failed.Path, failed.Error)
+			failures++
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if failures > 0 {
+		return fmt.Errorf("%d folders couldn't be listed, the listing is partial", failures)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ghchinoy/atmotool/output"
+)
+
+func TestListCMSForbiddenSubtree(t *testing.T) {
+	s := newTestCM(t)
+	s.PutFile("/content/home/landing/index.htm", []byte("<h1>hi</h1>"))
+	s.PutFile("/content/private/secret.htm", []byte("no"))
+	s.FailPath("/content/private", http.StatusForbidden)
+
+	var buf bytes.Buffer
+	format = output.Options{Format: output.JSON, Out: &buf}
+	err := listCMS([]string{"/content"}, 0, false)
+	if err == nil || !strings.Contains(err.Error(), "1 folders") {
+		t.Errorf("listCMS with a forbidden folder = %v, want an error counting it", err)
+	}
+
+	var tree cmsNode
+	if err := json.Unmarshal(buf.Bytes(), &tree); err != nil {
+		t.Fatalf("listing isn't JSON: %v\n%s", err, buf.String())
+	}
+	found := map[string]string{}
+	var walk func(n *cmsNode)
+	walk = func(n *cmsNode) {
+		found[n.Path] = n.Error
+		for _, c := range n.Children {
+			walk(c)
+		}
+	}
+	walk(&tree)
+	if _, ok := found["/content/home/landing/index.htm"]; !ok {
+		t.Errorf("listing is missing the readable file: %v", found)
+	}
+	if e, ok := found["/content/private"]; !ok || e == "" {
+		t.Errorf("forbidden folder = %q, %v, want it listed with its error", e, ok)
+	}
+	if _, ok := found["/content/private/secret.htm"]; ok {
+		t.Errorf("listing has a file below the forbidden folder")
+	}
+}
+
+func TestListCMSDepth(t *testing.T) {
+	s := newTestCM(t)
+	s.PutFile("/resources/theme/default/less/custom.less", []byte("a {}"))
+
+	tree, err := cmsTree("/resources", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tree.Children) != 1 || tree.Children[0].Path != "/resources/theme" {
+		t.Fatalf("children of /resources = %+v", tree.Children)
+	}
+	theme := tree.Children[0]
+	if len(theme.Children) != 1 || len(theme.Children[0].Children) != 0 {
+		t.Errorf("depth 2 listed below /resources/theme/default: %+v", theme.Children)
+	}
+}
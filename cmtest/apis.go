@@ -0,0 +1,428 @@
+package cmtest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghchinoy/atmotool/cm"
+)
+
+// API is an API, with a single version, held by the Server
+type API struct {
+	ID         string
+	Name       string
+	VersionID  string
+	Version    string
+	Visibility string
+	Endpoint   string
+	Created    time.Time
+}
+
+// AddAPI adds an API with one version to the Server and returns it
+func (s *Server) AddAPI(name string, version string, visibility string, endpoint string) API {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *s.addAPI(name, version, visibility, endpoint)
+}
+
+func (s *Server) addAPI(name string, version string, visibility string, endpoint string) *API {
+	if version == "" {
+		version = "1.0"
+	}
+	if visibility == "" {
+		visibility = "Public"
+	}
+	api := &API{
+		ID:         newID(),
+		Name:       name,
+		VersionID:  newID(),
+		Version:    version,
+		Visibility: visibility,
+		Endpoint:   endpoint,
+		Created:    time.Now(),
+	}
+	s.apis = append(s.apis, api)
+	return api
+}
+
+// APIs returns the APIs held by the Server
+func (s *Server) APIs() []API {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var apis []API
+	for _, api := range s.apis {
+		apis = append(apis, *api)
+	}
+	return apis
+}
+
+// AddApp adds an app, found by a type:app search, and returns its guid
+func (s *Server) AddApp(name string, visibility string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	guid := newID() + "." + s.tenant
+	s.apps = append(s.apps, cm.Item{
+		Title:    name,
+		Guid:     cm.Guid{Value: guid},
+		Category: categories("app", visibility),
+		PubDate:  time.Now().UTC().Format(time.RFC1123Z),
+	})
+	return guid
+}
+
+// AddUser adds a user, found by a type:user search, and returns its guid
+func (s *Server) AddUser(name string, email string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	guid := newID() + "." + s.tenant
+	s.users = append(s.users, cm.Item{
+		Title:       name,
+		Description: name,
+		Category:    categories("user", "Registered Users"),
+		Guid:        cm.Guid{Value: guid},
+		Email:       email,
+		UserName:    email,
+		Domain:      "Local Domain",
+		PubDate:     time.Now().UTC().Format(time.RFC1123Z),
+	})
+	return guid
+}
+
+func visibilityCategory(visibility string) []cm.ValueDomain {
+	return []cm.ValueDomain{{Value: visibility, Domain: "uddi:soa.com:visibility"}}
+}
+
+// categories are the type and visibility categories of a search result
+func categories(kind string, visibility string) []cm.ValueDomain {
+	return append([]cm.ValueDomain{{Value: kind, Domain: "uddi:soa.com:resourcetype"}}, visibilityCategory(visibility)...)
+}
+
+// apiItem is an API as listed by /api/apis
+func (s *Server) apiItem(api *API) cm.Item {
+	item := cm.Item{
+		Title:       api.Name,
+		Description: api.Name,
+		Category:    categories("api", api.Visibility),
+		Guid:        cm.Guid{Value: api.ID + "." + s.tenant},
+		PubDate:     api.Created.UTC().Format(time.RFC1123Z),
+	}
+	item.EntityReference = cm.EntityReference{
+		Title: api.Name + " (" + api.Version + ")",
+		Guid:  api.VersionID + "." + s.tenant,
+	}
+	return item
+}
+
+// versionItem is an API version as listed by /api/apis/versions
+func (s *Server) versionItem(api *API) cm.Item {
+	item := cm.Item{
+		Title:    api.Version,
+		Category: visibilityCategory(api.Visibility),
+		Guid:     cm.Guid{Value: api.VersionID + "." + s.tenant},
+		PubDate:  api.Created.UTC().Format(time.RFC1123Z),
+	}
+	item.EntityReferences.EntityReference = []cm.EntityReference{{Title: api.Name, Guid: api.ID + "." + s.tenant}}
+	if api.Endpoint != "" {
+		item.Endpoints.Endpoint = []cm.Endpoint{{URI: api.Endpoint}}
+	}
+	return item
+}
+
+// handleAPIs lists the APIs, or creates one from a name, an endpoint or a dropbox spec
+func (s *Server) handleAPIs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		s.mu.Lock()
+		var items []cm.Item
+		for _, api := range s.apis {
+			items = append(items, s.apiItem(api))
+		}
+		s.mu.Unlock()
+		writeJSON(w, page(items, r, len(items)))
+	case "POST":
+		var create struct {
+			APIVersionInfo struct {
+				Name string
+			}
+			AddAPIImplementationRequest struct {
+				ProxyImplementationRequest struct {
+					TargetEndpointURL []string
+				}
+			}
+			DLDescriptor struct {
+				ServiceDescriptorReference struct {
+					ServiceName string
+				}
+			}
+		}
+		if err := json.NewDecoder(r.Body).Decode(&create); err != nil {
+			fault(w, http.StatusBadRequest, "BadRequest", err.Error())
+			return
+		}
+		name := create.APIVersionInfo.Name
+		if name == "" {
+			name = create.DLDescriptor.ServiceDescriptorReference.ServiceName
+		}
+		if name == "" {
+			fault(w, http.StatusBadRequest, "BadRequest", "No API name given")
+			return
+		}
+		var endpoint string
+		if targets := create.AddAPIImplementationRequest.ProxyImplementationRequest.TargetEndpointURL; len(targets) > 0 {
+			endpoint = targets[0]
+		}
+
+		s.mu.Lock()
+		api := s.addAPI(name, "", "", endpoint)
+		s.mu.Unlock()
+
+		var created cm.APICreatedResponse
+		created.APIID = api.ID
+		created.Name = api.Name
+		created.Visibility = api.Visibility
+		created.LatestVersionID = api.VersionID
+		created.APIVersion.APIVersionID = api.VersionID
+		created.APIVersion.APIID = api.ID
+		created.APIVersion.Name = api.Version
+		created.APIVersion.Visibility = api.Visibility
+		created.APIVersion.ProductionEndpoint = api.Endpoint
+		created.Created = api.Created.Format(time.RFC3339)
+		created.Updated = created.Created
+		writeJSON(w, created)
+	default:
+		fault(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method+" is not supported on /api/apis")
+	}
+}
+
+func (s *Server) handleAPIVersions(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	var items []cm.Item
+	for _, api := range s.apis {
+		items = append(items, s.versionItem(api))
+	}
+	s.mu.Unlock()
+	writeJSON(w, page(items, r, len(items)))
+}
+
+// findAPI returns the API with an ID or version ID, with or without the
+// tenant suffix, and whether the ID is of a version
+func (s *Server) findAPI(id string) (api *API, isVersion bool) {
+	id = strings.TrimSuffix(id, "."+s.tenant)
+	for _, api := range s.apis {
+		if api.ID == id {
+			return api, false
+		}
+		if api.VersionID == id {
+			return api, true
+		}
+	}
+	return nil, false
+}
+
+// apiVersion is an API version as returned by /api/apis/versions/{id}
+func (s *Server) apiVersion(api *API) cm.APIVersion {
+	var version cm.APIVersion
+	version.APIVersionID = api.VersionID + "." + s.tenant
+	version.APIID = api.ID + "." + s.tenant
+	version.Name = api.Version
+	version.Description = api.Name
+	version.ProductionEndpoint = api.Endpoint
+	return version
+}
+
+// handleAPI answers /api/apis/{id} with the API and its latest version. As CM
+// does, a version ID is a 500 fault naming [apiversion].
+func (s *Server) handleAPI(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	api, isVersion := s.findAPI(strings.TrimPrefix(r.URL.Path, "/api/apis/"))
+	if api == nil {
+		fault(w, http.StatusNotFound, "NotFound", "No API "+r.URL.Path)
+		return
+	}
+	if isVersion {
+		fault(w, http.StatusInternalServerError, "InvalidInput", "Invalid ID for [apiversion]")
+		return
+	}
+	details := cm.APIDetails{
+		APIID:           api.ID + "." + s.tenant,
+		Name:            api.Name,
+		Description:     api.Name,
+		Visibility:      api.Visibility,
+		LatestVersionID: api.VersionID + "." + s.tenant,
+		APIVersion:      s.apiVersion(api),
+		Created:         api.Created,
+		Updated:         api.Created,
+	}
+	writeJSON(w, details)
+}
+
+// handleAPIVersion answers /api/apis/versions/{id}, with its metrics, which
+// are a single empty interval, and its txlogs/export, which are empty. As CM
+// does, an API ID is a 500 fault naming [api].
+func (s *Server) handleAPIVersion(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/api/apis/versions/"), "/", 2)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	api, isVersion := s.findAPI(parts[0])
+	if api == nil {
+		fault(w, http.StatusNotFound, "NotFound", "No API version "+parts[0])
+		return
+	}
+	if !isVersion {
+		fault(w, http.StatusInternalServerError, "InvalidInput", "Invalid ID for [api]")
+		return
+	}
+	var sub string
+	if len(parts) > 1 {
+		sub = parts[1]
+	}
+	switch sub {
+	case "":
+		writeJSON(w, s.apiVersion(api))
+	case "metrics":
+		start := time.Now().UTC().Truncate(time.Hour).Format(time.RFC3339)
+		writeJSON(w, map[string]interface{}{
+			"StartTime": start,
+			"EndTime":   start,
+			"Interval": []map[string]interface{}{{
+				"StartTime": start,
+				"Metric":    []map[string]interface{}{{"Name": "totalCount", "Value": 0}},
+			}},
+		})
+	case "txlogs/export":
+		w.Header().Set("Content-Type", "text/csv")
+	default:
+		fault(w, http.StatusNotFound, "NotFound", "No fake CM endpoint for "+r.URL.Path)
+	}
+}
+
+// handleSearch answers q=type:app, type:user or type:api, with optional
+// further words matched against titles, paged by start and count
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var kind string
+	var words []string
+	for _, term := range strings.Fields(query.Get("q")) {
+		if strings.HasPrefix(term, "type:") {
+			kind = strings.TrimPrefix(term, "type:")
+		} else {
+			words = append(words, strings.ToLower(term))
+		}
+	}
+
+	s.mu.Lock()
+	var candidates []cm.Item
+	switch kind {
+	case "app":
+		candidates = append(candidates, s.apps...)
+	case "user":
+		candidates = append(candidates, s.users...)
+	case "api":
+		for _, api := range s.apis {
+			candidates = append(candidates, s.apiItem(api))
+		}
+	default:
+		candidates = append(append(candidates, s.apps...), s.users...)
+		for _, api := range s.apis {
+			candidates = append(candidates, s.apiItem(api))
+		}
+	}
+	s.mu.Unlock()
+
+	var matches []cm.Item
+	for _, item := range candidates {
+		title := strings.ToLower(item.Title)
+		match := true
+		for _, word := range words {
+			if !strings.Contains(title, word) {
+				match = false
+			}
+		}
+		if match {
+			matches = append(matches, item)
+		}
+	}
+
+	results := page(matches, r, 20)
+	results.Channel.Title = "Search results"
+	writeJSON(w, results)
+}
+
+// page returns the items selected by the start and count parameters of r,
+// with the total, returning defaultCount items if no count is given
+func page(items []cm.Item, r *http.Request, defaultCount int) cm.ApisResponse {
+	query := r.URL.Query()
+	start, _ := strconv.Atoi(query.Get("start"))
+	count, err := strconv.Atoi(query.Get("count"))
+	if err != nil || count <= 0 {
+		count = defaultCount
+	}
+	if start < 0 {
+		start = 0
+	} else if start > len(items) {
+		start = len(items)
+	}
+	end := start + count
+	if end > len(items) {
+		end = len(items)
+	}
+
+	var list cm.ApisResponse
+	list.Channel.TotalResults = len(items)
+	list.Channel.StartIndex = start
+	list.Channel.ItemsPerPage = count
+	list.Channel.Items = items[start:end]
+	return list
+}
+
+// handleDropbox accepts a FileName upload and describes it as a spec with
+// one service, named by its info.title when it's an OpenAPI JSON document
+func (s *Server) handleDropbox(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		fault(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "The dropbox requires a POST")
+		return
+	}
+	upload, header, err := r.FormFile("FileName")
+	if err != nil {
+		fault(w, http.StatusBadRequest, "BadRequest", "No FileName in upload: "+err.Error())
+		return
+	}
+	defer upload.Close()
+	content, err := ioutil.ReadAll(upload)
+	if err != nil {
+		fault(w, http.StatusBadRequest, "BadRequest", err.Error())
+		return
+	}
+
+	name := strings.TrimSuffix(filepath.Base(header.Filename), filepath.Ext(header.Filename))
+	var spec struct {
+		Info struct {
+			Title string `json:"title"`
+		} `json:"info"`
+	}
+	if json.Unmarshal(content, &spec) == nil && spec.Info.Title != "" {
+		name = spec.Info.Title
+	}
+
+	s.mu.Lock()
+	s.dropbox++
+	id := s.dropbox
+	s.mu.Unlock()
+
+	writeJSON(w, map[string]interface{}{
+		"FileName":      header.Filename,
+		"FileType":      "SWAGGER",
+		"DropboxFileId": id,
+		"ServiceDescriptorDocument": []map[string]interface{}{{
+			"FileName":       header.Filename,
+			"DescriptorType": "SWAGGER",
+			"ServiceName":    []string{name},
+		}},
+	})
+}
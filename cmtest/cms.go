@@ -0,0 +1,317 @@
+package cmtest
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ghchinoy/atmotool/cm"
+)
+
+// file is a CMS file held by the Server
+type file struct {
+	content  []byte
+	modified time.Time
+}
+
+// cleanPath normalizes a CMS path to /a/b form
+func cleanPath(p string) string {
+	return path.Clean("/" + strings.Trim(p, "/"))
+}
+
+// PutFile stores content at a CMS path, as an upload would
+func (s *Server) PutFile(p string, content []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.putFile(cleanPath(p), content)
+}
+
+func (s *Server) putFile(p string, content []byte) {
+	s.files[p] = &file{content: append([]byte(nil), content...), modified: time.Now()}
+	s.putFolder(path.Dir(p))
+}
+
+// putFolder creates the folder at p and the folders above it
+func (s *Server) putFolder(p string) {
+	now := time.Now()
+	for dir := p; dir != "/"; dir = path.Dir(dir) {
+		if _, ok := s.folders[dir]; !ok {
+			s.folders[dir] = now
+		}
+	}
+}
+
+// File returns the content of a CMS file and whether it exists
+func (s *Server) File(p string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[cleanPath(p)]
+	if !ok {
+		return nil, false
+	}
+	return append([]byte(nil), f.content...), true
+}
+
+// Files returns the paths of all CMS files below a CMS folder, sorted
+func (s *Server) Files(folder string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prefix := strings.TrimSuffix(cleanPath(folder), "/") + "/"
+	var paths []string
+	for p := range s.files {
+		if strings.HasPrefix(p, prefix) {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// entries returns the names of the files and folders directly in a folder, sorted
+func (s *Server) entries(folder string) (files []string, folders []string) {
+	prefix := strings.TrimSuffix(folder, "/") + "/"
+	for p := range s.files {
+		if path.Dir(p) == folder {
+			files = append(files, strings.TrimPrefix(p, prefix))
+		}
+	}
+	for p := range s.folders {
+		if p != folder && path.Dir(p) == folder {
+			folders = append(folders, strings.TrimPrefix(p, prefix))
+		}
+	}
+	sort.Strings(files)
+	sort.Strings(folders)
+	return files, folders
+}
+
+// failure is a fault the CMS answers for a path, times more times, or
+// always if times is 0
+type failure struct {
+	status     int
+	times      int
+	retryAfter string
+}
+
+// FailPath makes the CMS answer every request for p with a fault of the
+// given status, ex. a 403 for a folder the user can't list
+func (s *Server) FailPath(p string, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[cleanPath(p)] = &failure{status: status}
+}
+
+// FailPathTimes makes the CMS answer the next times requests for p with a
+// fault of the given status, with a Retry-After header unless retryAfter is
+// blank, ex. a 503 of an overloaded CM
+func (s *Server) FailPathTimes(p string, status int, times int, retryAfter string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[cleanPath(p)] = &failure{status: status, times: times, retryAfter: retryAfter}
+}
+
+func (s *Server) handleCMS(w http.ResponseWriter, r *http.Request) {
+	p := cleanPath(r.URL.Path)
+	s.mu.Lock()
+	f, fails := s.failures[p]
+	if fails && f.times > 0 {
+		if f.times--; f.times == 0 {
+			delete(s.failures, p)
+		}
+	}
+	s.mu.Unlock()
+	if fails {
+		if f.retryAfter != "" {
+			w.Header().Set("Retry-After", f.retryAfter)
+		}
+		fault(w, f.status, http.StatusText(f.status), "Failing "+p+" as asked")
+		return
+	}
+	switch r.Method {
+	case "GET":
+		s.getCMS(w, r, p)
+	case "POST":
+		s.postCMS(w, r, p)
+	case "DELETE":
+		s.deleteCMS(w, p)
+	default:
+		fault(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method+" is not supported on the CMS")
+	}
+}
+
+// getCMS answers a file's content, a folder's listing, or with
+// ?download=true&Zip=true, a zip of either
+func (s *Server) getCMS(w http.ResponseWriter, r *http.Request, p string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, isFile := s.files[p]
+	_, isFolder := s.folders[p]
+	if !isFile && !isFolder {
+		fault(w, http.StatusNotFound, "NotFound", "No CMS resource at "+p)
+		return
+	}
+
+	if r.URL.Query().Get("download") == "true" && strings.EqualFold(r.URL.Query().Get("Zip"), "true") {
+		b, err := s.zip(p, isFile)
+		if err != nil {
+			fault(w, http.StatusInternalServerError, "ZipFailed", err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/zip")
+		w.Write(b)
+		return
+	}
+
+	if isFile {
+		w.Header().Set("Content-Type", http.DetectContentType(f.content))
+		w.Write(f.content)
+		return
+	}
+
+	files, folders := s.entries(p)
+	var listing cm.ApisResponse
+	listing.Channel.Title = p
+	for _, name := range folders {
+		listing.Channel.Items = append(listing.Channel.Items, cmsItem(p, name, "folder", s.folders[path.Join(p, name)]))
+	}
+	for _, name := range files {
+		listing.Channel.Items = append(listing.Channel.Items, cmsItem(p, name, "file", s.files[path.Join(p, name)].modified))
+	}
+	writeJSON(w, listing)
+}
+
+func cmsItem(folder string, name string, kind string, modified time.Time) cm.Item {
+	return cm.Item{
+		Title:    name,
+		Category: []cm.ValueDomain{{Value: kind, Domain: "uddi:soa.com:cms:type"}},
+		Guid:     cm.Guid{Value: path.Join(folder, name)},
+		PubDate:  modified.UTC().Format(time.RFC1123Z),
+	}
+}
+
+// zip returns a zip of the file at p, or of everything below the folder at p
+// with names relative to it
+func (s *Server) zip(p string, isFile bool) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	add := func(name string, f *file) error {
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: f.modified})
+		if err != nil {
+			return err
+		}
+		_, err = fw.Write(f.content)
+		return err
+	}
+	if isFile {
+		if err := add(path.Base(p), s.files[p]); err != nil {
+			return nil, err
+		}
+	} else {
+		prefix := strings.TrimSuffix(p, "/") + "/"
+		var names []string
+		for fp := range s.files {
+			if strings.HasPrefix(fp, prefix) {
+				names = append(names, fp)
+			}
+		}
+		sort.Strings(names)
+		for _, fp := range names {
+			if err := add(strings.TrimPrefix(fp, prefix), s.files[fp]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// postCMS stores the multipart File upload in the folder at p, unpacking a
+// zip, including its folder entries, when ?unpack=true
+func (s *Server) postCMS(w http.ResponseWriter, r *http.Request, p string) {
+	upload, header, err := r.FormFile("File")
+	if err != nil {
+		fault(w, http.StatusBadRequest, "BadRequest", "No File in upload: "+err.Error())
+		return
+	}
+	defer upload.Close()
+	content, err := ioutil.ReadAll(upload)
+	if err != nil {
+		fault(w, http.StatusBadRequest, "BadRequest", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, isFile := s.files[p]; isFile {
+		fault(w, http.StatusConflict, "Conflict", p+" is a file, not a folder")
+		return
+	}
+
+	if r.URL.Query().Get("unpack") == "true" && strings.HasSuffix(strings.ToLower(header.Filename), ".zip") {
+		zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+		if err != nil {
+			fault(w, http.StatusBadRequest, "BadZip", err.Error())
+			return
+		}
+		for _, zf := range zr.File {
+			if strings.HasSuffix(zf.Name, "/") {
+				s.putFolder(cleanPath(path.Join(p, zf.Name)))
+				continue
+			}
+			rc, err := zf.Open()
+			if err != nil {
+				fault(w, http.StatusBadRequest, "BadZip", err.Error())
+				return
+			}
+			b, err := ioutil.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				fault(w, http.StatusBadRequest, "BadZip", err.Error())
+				return
+			}
+			s.putFile(cleanPath(path.Join(p, zf.Name)), b)
+		}
+	} else {
+		s.putFile(cleanPath(path.Join(p, path.Base(header.Filename))), content)
+	}
+	writeJSON(w, map[string]string{"result": "success"})
+}
+
+// deleteCMS removes the file or folder, and everything below it, at p
+func (s *Server) deleteCMS(w http.ResponseWriter, p string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if p == "/content" || p == "/resources" {
+		fault(w, http.StatusForbidden, "Forbidden", "Can't delete "+p)
+		return
+	}
+	_, isFile := s.files[p]
+	_, isFolder := s.folders[p]
+	if !isFile && !isFolder {
+		fault(w, http.StatusNotFound, "NotFound", "No CMS resource at "+p)
+		return
+	}
+	delete(s.files, p)
+	delete(s.folders, p)
+	prefix := p + "/"
+	for fp := range s.files {
+		if strings.HasPrefix(fp, prefix) {
+			delete(s.files, fp)
+		}
+	}
+	for fp := range s.folders {
+		if strings.HasPrefix(fp, prefix) {
+			delete(s.folders, fp)
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
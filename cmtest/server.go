@@ -0,0 +1,271 @@
+// Package cmtest provides an in-process fake Community Manager for testing
+// atmotool against, in the manner of net/http/httptest.
+//
+// The fake emulates the CM endpoints atmotool uses: login with the CSRF
+// cookie, API and API version listings and creation, search, the CMS
+// (list, get, upload with unpack, zip download and delete), rebuilding
+// styles and the dropbox. State is kept in memory, so an upload followed
+// by a listing sees the uploaded files. Rebuilding styles fails when a
+// theme's less files have unbalanced braces, as a compile error would.
+//
+//	s := cmtest.NewServer()
+//	defer s.Close()
+//	s.PutFile("/content/home/landing/index.htm", []byte("<h1>hi</h1>"))
+//	client, err := control.NewClient(s.Config(), false)
+package cmtest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghchinoy/atmotool/cm"
+	"github.com/ghchinoy/atmotool/control"
+)
+
+const (
+	// Email is the login accepted by a new Server
+	Email = "administrator@cm.demo"
+	// Password is the password accepted by a new Server
+	Password = "password"
+	// Tenant is the tenant of a new Server, appended to the guids it reports
+	Tenant = "cmdemo"
+)
+
+// Server is a fake Community Manager listening on a local port
+type Server struct {
+	*httptest.Server
+
+	// Email and Password are the credentials accepted by /api/login
+	Email    string
+	Password string
+
+	mu       sync.Mutex
+	tenant   string
+	sessions map[string]string // auth token -> CSRF token
+	requests []string
+	files    map[string]*file
+	folders  map[string]time.Time
+	apis     []*API
+	apps     []cm.Item
+	users    []cm.Item
+	rebuilds []string
+	dropbox  int
+	failures map[string]*failure // CMS path -> fault to answer
+}
+
+// NewServer starts and returns a fake CM. The caller should call Close when finished.
+func NewServer() *Server {
+	s := &Server{
+		Email:    Email,
+		Password: Password,
+		tenant:   Tenant,
+		sessions: make(map[string]string),
+		files:    make(map[string]*file),
+		folders:  make(map[string]time.Time),
+		failures: make(map[string]*failure),
+	}
+	now := time.Now()
+	s.folders["/content"] = now
+	s.folders["/resources"] = now
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/login", s.handleLogin)
+	mux.Handle("/api/apis", s.authenticated(s.handleAPIs))
+	mux.Handle("/api/apis/", s.authenticated(s.handleAPI))
+	mux.Handle("/api/apis/versions", s.authenticated(s.handleAPIVersions))
+	mux.Handle("/api/apis/versions/", s.authenticated(s.handleAPIVersion))
+	mux.Handle("/api/search", s.authenticated(s.handleSearch))
+	mux.Handle("/api/dropbox/readfiledetails", s.authenticated(s.handleDropbox))
+	mux.Handle("/resources/branding/generatestyles", s.authenticated(s.handleGenerateStyles))
+	for _, root := range []string{"/content", "/resources"} {
+		mux.Handle(root, s.authenticated(s.handleCMS))
+		mux.Handle(root+"/", s.authenticated(s.handleCMS))
+	}
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fault(w, http.StatusNotFound, "NotFound", "No fake CM endpoint for "+r.URL.Path)
+	})
+
+	s.Server = httptest.NewServer(s.logged(mux))
+	return s
+}
+
+// Config returns a Configuration that logs in to the Server, without retries
+func (s *Server) Config() control.Configuration {
+	return control.Configuration{
+		URL:      s.URL,
+		Email:    s.Email,
+		Password: s.Password,
+		Retries:  -1,
+	}
+}
+
+// Tenant returns the tenant suffix of the guids the Server reports
+func (s *Server) Tenant() string {
+	return s.tenant
+}
+
+// Requests returns the method and request URI of every request received, in order
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// ExpireSessions invalidates every login, so the next call gets a 401
+func (s *Server) ExpireSessions() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions = make(map[string]string)
+}
+
+// Rebuilds returns the themes whose styles were rebuilt, in order
+func (s *Server) Rebuilds() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.rebuilds...)
+}
+
+func (s *Server) logged(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests = append(s.requests, r.Method+" "+r.URL.RequestURI())
+		s.mu.Unlock()
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) authCookie() string {
+	return "AtmoAuthToken_" + s.tenant
+}
+
+func (s *Server) csrfCookie() string {
+	return "Csrf-Token_" + s.tenant
+}
+
+// authenticated requires the login cookie, and the CSRF header for anything but a GET
+func (s *Server) authenticated(h http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := r.Cookie(s.authCookie())
+		var csrf string
+		valid := false
+		if err == nil {
+			s.mu.Lock()
+			csrf, valid = s.sessions[c.Value]
+			s.mu.Unlock()
+		}
+		if !valid {
+			fault(w, http.StatusUnauthorized, "Unauthorized", "Not logged in")
+			return
+		}
+		if r.Method != "GET" && r.Header.Get("X-"+s.csrfCookie()) != csrf {
+			fault(w, http.StatusForbidden, "Forbidden", "Missing or invalid CSRF token")
+			return
+		}
+		h(w, r)
+	})
+}
+
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		fault(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "Login requires a POST")
+		return
+	}
+	var auth control.Auth
+	if err := json.NewDecoder(r.Body).Decode(&auth); err != nil {
+		fault(w, http.StatusBadRequest, "BadRequest", err.Error())
+		return
+	}
+	if auth.Email != s.Email || auth.Password != s.Password {
+		fault(w, http.StatusUnauthorized, "Unauthorized", "Invalid email or password")
+		return
+	}
+
+	token, csrf := newID(), newID()
+	s.mu.Lock()
+	s.sessions[token] = csrf
+	s.mu.Unlock()
+
+	http.SetCookie(w, &http.Cookie{Name: s.authCookie(), Value: token, Path: "/", HttpOnly: true})
+	http.SetCookie(w, &http.Cookie{Name: s.csrfCookie(), Value: csrf, Path: "/"})
+	writeJSON(w, control.UserInfo{
+		UserName:            auth.Email,
+		Status:              "Active",
+		UserFDN:             auth.Email + "." + s.tenant,
+		LoginState:          "LoggedIn",
+		AuthTokenValidUntil: time.Now().Add(30 * time.Minute).Format(time.RFC3339),
+		LoginDomainID:       "tenantbusiness." + s.tenant,
+	})
+}
+
+func (s *Server) handleGenerateStyles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		fault(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "Rebuilding styles requires a POST")
+		return
+	}
+	theme := r.FormValue("theme")
+	if theme == "" {
+		fault(w, http.StatusBadRequest, "BadRequest", "No theme given")
+		return
+	}
+	s.mu.Lock()
+	s.rebuilds = append(s.rebuilds, theme)
+	problem := s.checkLess(theme)
+	s.mu.Unlock()
+	if problem != "" {
+		fault(w, http.StatusInternalServerError, "LessCompileError", problem)
+		return
+	}
+	writeJSON(w, map[string]string{"result": "success"})
+}
+
+// checkLess stands in for compiling a theme's less files, reporting the
+// first file whose braces don't balance
+func (s *Server) checkLess(theme string) string {
+	prefix := "/resources/theme/" + theme + "/less/"
+	var names []string
+	for p := range s.files {
+		if strings.HasPrefix(p, prefix) && strings.HasSuffix(p, ".less") {
+			names = append(names, p)
+		}
+	}
+	sort.Strings(names)
+	for _, p := range names {
+		depth := 0
+		for i, line := range strings.Split(string(s.files[p].content), "\n") {
+			depth += strings.Count(line, "{") - strings.Count(line, "}")
+			if depth < 0 {
+				return fmt.Sprintf("Unrecognised input in %s on line %d", strings.TrimPrefix(p, prefix), i+1)
+			}
+		}
+		if depth > 0 {
+			return fmt.Sprintf("Missing closing '}' in %s", strings.TrimPrefix(p, prefix))
+		}
+	}
+	return ""
+}
+
+// fault writes a CM style fault
+func fault(w http.ResponseWriter, status int, code string, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(cm.ApisResponse{FaultCode: code, FaultMessage: message})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
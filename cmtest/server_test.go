@@ -0,0 +1,224 @@
+package cmtest
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ghchinoy/atmotool/cm"
+)
+
+// session is a client logged in to a Server, without atmotool's control
+// package, so what the fake requires is tested rather than assumed
+type session struct {
+	t    *testing.T
+	s    *Server
+	http *http.Client
+	csrf string
+}
+
+// login logs in to s with a password, returning the response's status code
+func login(t *testing.T, s *Server, password string) (*session, int) {
+	t.Helper()
+	jar, _ := cookiejar.New(nil)
+	c := &session{t: t, s: s, http: &http.Client{Jar: jar}}
+	body, _ := json.Marshal(map[string]string{"email": s.Email, "password": password})
+	resp, err := c.http.Post(s.URL+"/api/login", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	u, _ := url.Parse(s.URL)
+	for _, cookie := range jar.Cookies(u) {
+		if cookie.Name == s.csrfCookie() {
+			c.csrf = cookie.Value
+		}
+	}
+	return c, resp.StatusCode
+}
+
+// do sends a request, with the CSRF header if csrf, returning the status
+// code and body
+func (c *session) do(method string, p string, contentType string, body io.Reader, csrf bool) (int, []byte) {
+	c.t.Helper()
+	req, err := http.NewRequest(method, c.s.URL+p, body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if csrf {
+		req.Header.Set("X-"+c.s.csrfCookie(), c.csrf)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return resp.StatusCode, b
+}
+
+// upload posts content as the File of a multipart upload
+func (c *session) upload(p string, filename string, content []byte) int {
+	c.t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("File", filename)
+	fw.Write(content)
+	mw.Close()
+	status, _ := c.do("POST", p, mw.FormDataContentType(), &body, true)
+	return status
+}
+
+func TestLogin(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	if _, status := login(t, s, "wrong"); status != http.StatusUnauthorized {
+		t.Errorf("login with a wrong password = %d, want 401", status)
+	}
+	anonymous, _ := login(t, s, "wrong")
+	if status, _ := anonymous.do("GET", "/api/apis", "", nil, false); status != http.StatusUnauthorized {
+		t.Errorf("GET without a login = %d, want 401", status)
+	}
+
+	c, status := login(t, s, Password)
+	if status != http.StatusOK || c.csrf == "" {
+		t.Fatalf("login = %d, CSRF cookie %q", status, c.csrf)
+	}
+	if status, _ := c.do("GET", "/api/apis", "", nil, false); status != http.StatusOK {
+		t.Errorf("GET after login = %d", status)
+	}
+	form := url.Values{"theme": {"default"}}.Encode()
+	if status, _ := c.do("POST", "/resources/branding/generatestyles", "application/x-www-form-urlencoded", strings.NewReader(form), false); status != http.StatusForbidden {
+		t.Errorf("POST without the CSRF header = %d, want 403", status)
+	}
+	if status, _ := c.do("POST", "/resources/branding/generatestyles", "application/x-www-form-urlencoded", strings.NewReader(form), true); status != http.StatusOK {
+		t.Errorf("POST with the CSRF header = %d", status)
+	}
+	if got := s.Rebuilds(); !reflect.DeepEqual(got, []string{"default"}) {
+		t.Errorf("rebuilds = %v", got)
+	}
+
+	s.ExpireSessions()
+	if status, _ := c.do("GET", "/api/apis", "", nil, false); status != http.StatusUnauthorized {
+		t.Errorf("GET after the sessions expired = %d, want 401", status)
+	}
+	want := []string{"POST /api/login", "POST /api/login", "GET /api/apis", "POST /api/login", "GET /api/apis"}
+	if got := s.Requests(); !reflect.DeepEqual(got[:len(want)], want) {
+		t.Errorf("requests = %v", got)
+	}
+}
+
+func TestCMS(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c, _ := login(t, s, Password)
+	s.PutFile("content/home/landing/index.htm/", []byte("<h1>hi</h1>"))
+
+	status, b := c.do("GET", "/content/home/landing", "", nil, false)
+	var listing cm.ApisResponse
+	if err := json.Unmarshal(b, &listing); status != http.StatusOK || err != nil {
+		t.Fatalf("listing = %d, %v: %s", status, err, b)
+	}
+	if len(listing.Channel.Items) != 1 || listing.Channel.Items[0].Title != "index.htm" || listing.Channel.Items[0].Category[0].Value != "file" {
+		t.Errorf("listing = %+v", listing.Channel.Items)
+	}
+	if status, b := c.do("GET", "/content/home/landing/index.htm", "", nil, false); status != http.StatusOK || string(b) != "<h1>hi</h1>" {
+		t.Errorf("file = %d %q", status, b)
+	}
+
+	// a zip is unpacked with ?unpack=true, and kept as it is without
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	for name, content := range map[string]string{"css/site.css": "body {}", "img/": "", "img/logo.svg": "<svg/>"} {
+		w, _ := zw.Create(name)
+		w.Write([]byte(content))
+	}
+	zw.Close()
+	if status := c.upload("/content/home/landing?unpack=true", "site.zip", archive.Bytes()); status != http.StatusOK {
+		t.Fatalf("upload with unpack = %d", status)
+	}
+	if status := c.upload("/content/archive", "site.zip", archive.Bytes()); status != http.StatusOK {
+		t.Fatalf("upload = %d", status)
+	}
+	want := []string{"/content/home/landing/css/site.css", "/content/home/landing/img/logo.svg", "/content/home/landing/index.htm"}
+	if got := s.Files("/content/home/landing"); !reflect.DeepEqual(got, want) {
+		t.Errorf("files = %v, want %v", got, want)
+	}
+	if got, ok := s.File("/content/archive/site.zip"); !ok || !bytes.Equal(got, archive.Bytes()) {
+		t.Errorf("site.zip uploaded without unpack = %d bytes, %v", len(got), ok)
+	}
+	if status := c.upload("/content/home/landing/index.htm", "x.css", []byte("x")); status != http.StatusConflict {
+		t.Errorf("upload to a file = %d, want 409", status)
+	}
+
+	status, b = c.do("GET", "/content/home/landing?download=true&Zip=true", "", nil, false)
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if status != http.StatusOK || err != nil {
+		t.Fatalf("zip download = %d, %v", status, err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	if want := []string{"css/site.css", "img/logo.svg", "index.htm"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("zip download = %v, want %v", names, want)
+	}
+
+	if status, _ := c.do("DELETE", "/content/home/landing/img", "", nil, true); status != http.StatusNoContent {
+		t.Errorf("delete = %d", status)
+	}
+	if status, _ := c.do("GET", "/content/home/landing/img/logo.svg", "", nil, false); status != http.StatusNotFound {
+		t.Errorf("file in a deleted folder = %d, want 404", status)
+	}
+	if status, _ := c.do("DELETE", "/content/home/landing/img", "", nil, true); status != http.StatusNotFound {
+		t.Errorf("deleting again = %d, want 404", status)
+	}
+	if status, _ := c.do("DELETE", "/content", "", nil, true); status != http.StatusForbidden {
+		t.Errorf("deleting /content = %d, want 403", status)
+	}
+}
+
+func TestAPIs(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	c, _ := login(t, s, Password)
+	api := s.AddAPI("Weather", "", "", "http://weather.example.com")
+
+	_, b := c.do("GET", "/api/apis", "", nil, false)
+	var list cm.ApisResponse
+	if err := json.Unmarshal(b, &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Channel.Items) != 1 {
+		t.Fatalf("apis = %+v", list.Channel.Items)
+	}
+	item := list.Channel.Items[0]
+	if item.Title != "Weather" || item.Guid.Value != api.ID+"."+Tenant || item.EntityReference.Title != "Weather (1.0)" || item.EntityReference.Guid != api.VersionID+"."+Tenant {
+		t.Errorf("api = %+v", item)
+	}
+
+	_, b = c.do("GET", "/api/apis/versions", "", nil, false)
+	if err := json.Unmarshal(b, &list); err != nil {
+		t.Fatal(err)
+	}
+	item = list.Channel.Items[0]
+	if item.Title != "1.0" || item.Guid.Value != api.VersionID+"."+Tenant || item.Endpoints.Endpoint[0].URI != "http://weather.example.com" {
+		t.Errorf("version = %+v", item)
+	}
+}
@@ -0,0 +1,159 @@
+package control
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path/filepath"
+
+	"github.com/ghchinoy/atmotool/cm"
+)
+
+// Client is a logged-in connection to a Community Manager
+type Client struct {
+	Config   Configuration
+	HTTP     *http.Client
+	UserInfo UserInfo
+	debug    bool
+}
+
+// NewClient logs in to the configured CM and returns a Client for it
+func NewClient(config Configuration, debug bool) (*Client, error) {
+	httpClient, u, err := LoginToCM(config, debug)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{Config: config, HTTP: httpClient, UserInfo: u, debug: debug}, nil
+}
+
+// Tenant returns the tenant ID that suffixes CM GUIDs: the configured Tenant,
+// or the tenant of the login domain, or "" if neither names one
+func (c *Client) Tenant() string {
+	if c.Config.Tenant != "" {
+		return c.Config.Tenant
+	}
+	return cm.TenantFromLoginDomainID(c.UserInfo.LoginDomainID)
+}
+
+// NewRequest creates a request for a CM path, accepting JSON and carrying the CSRF header
+func (c *Client) NewRequest(method string, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, c.Config.URL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	AddCsrfHeader(req, c.HTTP)
+	return req, nil
+}
+
+// Do sends a request and decodes a JSON response body into v. If v is a
+// *[]byte the raw body is stored instead, and a nil v discards the body.
+// Non-2xx responses and responses with a faultcode are returned as a *cm.FaultError.
+func (c *Client) Do(req *http.Request, v interface{}) error {
+	if c.debug {
+		log.Println("curl command:", CURLThis(c.HTTP, req))
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if c.debug {
+		log.Println(resp.Status)
+		DebugResponseHeader(resp)
+		log.Printf("%s", bodyBytes)
+	}
+
+	if fault := cm.NewFaultError(resp, bodyBytes); fault != nil {
+		return fault
+	}
+
+	switch target := v.(type) {
+	case nil:
+		return nil
+	case *[]byte:
+		*target = bodyBytes
+		return nil
+	}
+	return json.Unmarshal(bodyBytes, v)
+}
+
+// Get retrieves a CM path, decoding the response into v
+func (c *Client) Get(path string, v interface{}) error {
+	req, err := c.NewRequest("GET", path, nil)
+	if err != nil {
+		return err
+	}
+	return c.Do(req, v)
+}
+
+// Post sends body to a CM path with the given content type, decoding the response into v
+func (c *Client) Post(path string, contentType string, body []byte, v interface{}) error {
+	req, err := c.NewRequest("POST", path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	return c.Do(req, v)
+}
+
+// Delete removes a CM path, decoding the response into v
+func (c *Client) Delete(path string, v interface{}) error {
+	req, err := c.NewRequest("DELETE", path, nil)
+	if err != nil {
+		return err
+	}
+	return c.Do(req, v)
+}
+
+// Upload POSTs a local file as the multipart form field fieldName to a CM path,
+// decoding the response into v
+func (c *Client) Upload(path string, fieldName string, filePath string, v interface{}) error {
+	var progress *uploadProgress
+	if c.showsProgress() {
+		progress = &uploadProgress{name: filepath.Base(filePath)}
+	}
+	req, err := newFileUploadRequest(c.Config.URL+path, fieldName, filePath, progress)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json, text/html;q=0.9, */*;q=0.8")
+	AddCsrfHeader(req, c.HTTP)
+	if c.debug {
+		log.Println("* Upload Path", filePath)
+		DebugRequestHeader(req)
+	}
+	err = c.Do(req, v)
+	progress.end()
+	return err
+}
+
+// Download GETs a CM path and copies the response body to w, returning the number of bytes written
+func (c *Client) Download(path string, w io.Writer) (int64, error) {
+	req, err := c.NewRequest("GET", path, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", "*/*")
+	if c.debug {
+		log.Println("curl command:", CURLThis(c.HTTP, req))
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		bodyBytes, _ := ioutil.ReadAll(resp.Body)
+		return 0, cm.NewFaultError(resp, bodyBytes)
+	}
+	return io.Copy(w, resp.Body)
+}
@@ -0,0 +1,233 @@
+package control_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/ghchinoy/atmotool/cm"
+	"github.com/ghchinoy/atmotool/cmtest"
+	"github.com/ghchinoy/atmotool/control"
+)
+
+// newClient starts a fake CM and logs in to it, caching the login in a
+// temporary home
+func newClient(t *testing.T) (*cmtest.Server, *control.Client) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	s := cmtest.NewServer()
+	t.Cleanup(s.Close)
+	client, err := control.NewClient(s.Config(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, client
+}
+
+// logins counts the logins the fake CM has received
+func logins(s *cmtest.Server) int {
+	var n int
+	for _, r := range s.Requests() {
+		if r == "POST /api/login" {
+			n++
+		}
+	}
+	return n
+}
+
+func TestLoginAndCSRF(t *testing.T) {
+	s, client := newClient(t)
+	if client.UserInfo.UserName != cmtest.Email {
+		t.Errorf("logged in as %q", client.UserInfo.UserName)
+	}
+	if got := client.Tenant(); got != cmtest.Tenant {
+		t.Errorf("Tenant() = %q, want %q", got, cmtest.Tenant)
+	}
+
+	// a change carries the CSRF header of the login
+	var result map[string]string
+	err := client.Post("/resources/branding/generatestyles", "application/x-www-form-urlencoded", []byte(url.Values{"theme": {"default"}}.Encode()), &result)
+	if err != nil || result["result"] != "success" {
+		t.Fatalf("generatestyles = %v, %v", result, err)
+	}
+
+	// and is refused without it
+	resp, err := client.HTTP.PostForm(s.URL+"/resources/branding/generatestyles", url.Values{"theme": {"default"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("generatestyles without CSRF header = %s, want 403", resp.Status)
+	}
+}
+
+func TestLoginRefused(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	s := cmtest.NewServer()
+	defer s.Close()
+	config := s.Config()
+	config.Password = "wrong"
+
+	_, err := control.NewClient(config, false)
+	var login *control.LoginError
+	if !errors.As(err, &login) {
+		t.Fatalf("NewClient with a wrong password = %v, want a LoginError", err)
+	}
+	var fault *cm.FaultError
+	if !errors.As(err, &fault) || !fault.Unauthorized() {
+		t.Errorf("LoginError doesn't unwrap to a 401 fault: %v", err)
+	}
+}
+
+func TestLoginCached(t *testing.T) {
+	s, _ := newClient(t)
+	if _, err := control.NewClient(s.Config(), false); err != nil {
+		t.Fatal(err)
+	}
+	if n := logins(s); n != 1 {
+		t.Errorf("two clients logged in %d times, want the session reused", n)
+	}
+}
+
+func TestLoginAgainOn401(t *testing.T) {
+	s, client := newClient(t)
+	s.PutFile("/content/home/landing/index.htm", []byte("<h1>hi</h1>"))
+
+	s.ExpireSessions()
+	var listing cm.ApisResponse
+	if err := client.Get("/content/home/landing", &listing); err != nil {
+		t.Fatalf("GET after the session expired: %v", err)
+	}
+	if len(listing.Channel.Items) != 1 {
+		t.Errorf("listing = %+v", listing.Channel.Items)
+	}
+
+	// an upload's body is sent again, with the new CSRF token
+	s.ExpireSessions()
+	p := filepath.Join(t.TempDir(), "custom.less")
+	if err := ioutil.WriteFile(p, []byte("a {}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Upload("/resources/theme/default/less?unpack=false", "File", p, nil); err != nil {
+		t.Fatalf("upload after the session expired: %v", err)
+	}
+	if got, _ := s.File("/resources/theme/default/less/custom.less"); string(got) != "a {}" {
+		t.Errorf("custom.less = %q", got)
+	}
+	if n := logins(s); n != 3 {
+		t.Errorf("logged in %d times, want 3", n)
+	}
+}
+
+// zipOf returns a zip of files keyed by name
+func zipOf(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCMSUploadListDownloadDelete(t *testing.T) {
+	s, client := newClient(t)
+	files := map[string]string{
+		"index.htm":      "<h1>hi</h1>",
+		"img/banner.png": "\x89PNG",
+		"css/site.css":   "body {}",
+	}
+	archive := filepath.Join(t.TempDir(), "landing.zip")
+	if err := ioutil.WriteFile(archive, zipOf(t, files), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// unpacked into the folder
+	if err := client.Upload("/content/home/landing?unpack=true", "File", archive, nil); err != nil {
+		t.Fatal(err)
+	}
+	var listing cm.ApisResponse
+	if err := client.Get("/content/home/landing", &listing); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, v := range listing.Channel.Items {
+		names = append(names, v.Title+":"+v.Category[0].Value)
+	}
+	sort.Strings(names)
+	if want := "css:folder img:folder index.htm:file"; strings.Join(names, " ") != want {
+		t.Errorf("listing = %v, want %s", names, want)
+	}
+
+	// and kept as a zip without unpack
+	if err := client.Upload("/content/archive?unpack=false", "File", archive, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.File("/content/archive/landing.zip"); !ok {
+		t.Error("zip uploaded without unpack isn't in the CMS")
+	}
+
+	var buf bytes.Buffer
+	if _, err := client.Download("/content/home/landing?download=true&Zip=true", &buf); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != len(files) {
+		t.Errorf("zip download has %d files, want %d", len(zr.File), len(files))
+	}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(rc)
+		rc.Close()
+		if string(b) != files[f.Name] {
+			t.Errorf("%s in the zip download = %q, want %q", f.Name, b, files[f.Name])
+		}
+	}
+
+	if err := client.Delete("/content/home/landing/img", nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Files("/content/home/landing"); len(got) != 2 {
+		t.Errorf("files after deleting img = %v", got)
+	}
+	err = client.Delete("/content/home/landing/img", nil)
+	var fault *cm.FaultError
+	if !errors.As(err, &fault) || !fault.NotFound() {
+		t.Errorf("deleting a deleted folder = %v, want a 404 fault", err)
+	}
+}
+
+func TestGenerateStylesFault(t *testing.T) {
+	s, client := newClient(t)
+	s.PutFile("/resources/theme/default/less/custom.less", []byte("a {\n  color: red;\n"))
+
+	err := client.Post("/resources/branding/generatestyles", "application/x-www-form-urlencoded", []byte("theme=default"), nil)
+	var fault *cm.FaultError
+	if !errors.As(err, &fault) || fault.FaultCode != "LessCompileError" || fault.Unauthorized() {
+		t.Fatalf("generatestyles with unbalanced braces = %v, want a LessCompileError fault", err)
+	}
+	if got := s.Rebuilds(); len(got) != 1 || got[0] != "default" {
+		t.Errorf("rebuilds = %v", got)
+	}
+}
@@ -8,10 +8,13 @@ import (
 	"log"
 	"net/http"
 	"net/http/cookiejar"
+	"net/url"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strings"
 
+	"github.com/ghchinoy/atmotool/cm"
 	"github.com/ghchinoy/atmotool/version"
 )
 
@@ -20,9 +23,44 @@ type Configuration struct {
 	URL             string `json:"url" mapstructure:"url"`
 	Email           string `json:"email" mapstructure:"email"`
 	Password        string `json:"password" mapstructure:"password"`
+	PasswordEnv     string `json:"passwordEnv,omitempty"`
+	PasswordCommand string `json:"passwordCommand,omitempty"`
 	Theme           string `json:"theme" mapstructure:"theme"`
 	ConsoleUsername string `json:"consoleUsername" mapstructure:"console-username"`
 	LoginDomainID   string `json:"loginDomainID"`
+	// Tenant is the tenant ID suffixing CM GUIDs, ex. acme in 4b5ba6ff.acme;
+	// by default it's taken from the login's loginDomainId
+	Tenant string `json:"tenant,omitempty"`
+	// Timeout is how long to wait for CM to respond, or for more of a response,
+	// ex. "30s"; "0" waits forever
+	Timeout string `json:"timeout,omitempty"`
+	// Retries is the number of retries of failed idempotent calls, -1 disables retrying
+	Retries         int    `json:"retries,omitempty"`
+	RetryBackoff    string `json:"retryBackoff,omitempty"`
+	RetryMaxBackoff string `json:"retryMaxBackoff,omitempty"`
+	// PageSize is the number of items requested per page of a listing
+	PageSize int `json:"pageSize,omitempty"`
+	// ResetProfiles are reset profiles by name, each a list of CMS paths in
+	// which {theme} stands for the theme reset
+	ResetProfiles map[string][]string `json:"resetProfiles,omitempty"`
+	// TLS and proxy settings for connecting to CM
+	CAFile             string            `json:"caFile,omitempty"`
+	ClientCert         string            `json:"clientCert,omitempty"`
+	ClientKey          string            `json:"clientKey,omitempty"`
+	InsecureSkipVerify bool              `json:"insecureSkipVerify,omitempty"`
+	ProxyURL           string            `json:"proxyURL,omitempty"`
+	Headers            map[string]string `json:"headers,omitempty"`
+	// RecordDir and ReplayDir are set from the command line to save CM
+	// exchanges to fixture files, or to serve them back without a network
+	RecordDir string `json:"-"`
+	ReplayDir string `json:"-"`
+	// DryRun, set from the command line, prints requests that would change CM
+	// as curl commands instead of sending them
+	DryRun bool `json:"-"`
+	// Limit, set from the command line, is the most items a listing returns, 0 for all
+	Limit int `json:"-"`
+	// Quiet, set from the command line, hides upload progress
+	Quiet bool `json:"-"`
 }
 
 // UserInfo is the logged-in user's information
@@ -37,69 +75,87 @@ type UserInfo struct {
 	PendingNotifications int    `json:"pendingNotifications"`
 }
 
-// InitializeConfiguration reads config (or local.conf)
-func InitializeConfiguration(configLocation string, debug bool) (Configuration, error) {
+// InitializeConfiguration reads config (or local.conf, or the current profile
+// of ~/.akana/config). If env is given, that named profile is used instead of
+// the configuration file's current profile.
+func InitializeConfiguration(configLocation string, env string, debug bool) (Configuration, error) {
 
 	var config Configuration
+	var err error
 
-	// if not specified, try ./local.conf, then try ~/.akana/local.conf
 	if configLocation == "" || configLocation == "<nil>" {
-		cwd, err := os.Getwd()
-		if err != nil {
-			fmt.Println("Cant't get working directory,")
-			return config, err
-		}
-
-		configLocation = cwd + "/local.conf"
-		config, err := tryConfig(configLocation)
-		if err != nil {
-			fmt.Println("Couldn't find config at ./local.conf")
+		if env != "" {
+			// a named profile can only come from the profiles file
+			configLocation = DefaultProfilesLocation()
+			config, err = tryConfig(configLocation, env)
 		} else {
-			return config, nil
+			// try ./local.conf, then ~/.akana/config, then ~/.akana/local.conf
+			config, configLocation, err = findConfig(debug)
 		}
-
-		configLocation = userHomeDir() + "/.akana/local.conf"
-		config, err = tryConfig(configLocation)
-		if err != nil {
-			fmt.Println("Couldn't find config at ~/.akana/local.conf")
-			return config, err
-		}
-
-		return config, nil
+	} else {
+		config, err = tryConfig(configLocation, env)
 	}
-
-	config, err := tryConfig(configLocation)
 	if err != nil {
 		return config, err
 	}
 
-	if len(config.Password) < 1 {
-		fmt.Printf("Missing or blank password.")
-		return config, err
-	}
-
 	if debug {
-		log.Println("Config file contents:", config)
+		log.Printf("Config file %s contents: %s", configLocation, config)
 	}
 
 	return config, nil
 }
 
-// extracting to make repeatable
-// for _, v := range pathstocheckarray {
-//	config, err := tryConfig(v)
-//  if err == nil {
-//   return config
-// }
-//}
-func tryConfig(configLocation string) (Configuration, error) {
+// findConfig looks for a configuration in the default locations
+func findConfig(debug bool) (Configuration, string, error) {
+	var config Configuration
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		fmt.Println("Cant't get working directory,")
+		return config, "", err
+	}
+
+	locations := []string{
+		filepath.Join(cwd, "local.conf"),
+		DefaultProfilesLocation(),
+		filepath.Join(userHomeDir(), ".akana", "local.conf"),
+	}
+	for _, location := range locations {
+		if _, err = os.Stat(location); err != nil {
+			if debug {
+				log.Println("No config at", location)
+			}
+			continue
+		}
+		config, err = tryConfig(location, "")
+		return config, location, err
+	}
+
+	fmt.Println("Couldn't find config at ./local.conf, ~/.akana/config or ~/.akana/local.conf")
+	return config, "", err
+}
+
+// tryConfig reads a configuration file, which may either be a single
+// Configuration or a Profiles file; for the latter the env profile, or the
+// current one if env is blank, is returned
+func tryConfig(configLocation string, env string) (Configuration, error) {
 	var config Configuration
 	configBytes, err := ioutil.ReadFile(configLocation)
 	if err != nil {
 		fmt.Printf("Error opening config file: %s\n", err)
 		return config, err
 	}
-	//var config Configuration
+
+	var profiles Profiles
+	err = json.Unmarshal(configBytes, &profiles)
+	if err == nil && len(profiles.Profiles) > 0 {
+		return profiles.Select(env)
+	}
+	if env != "" {
+		return config, fmt.Errorf("Config file %s has no profiles, can't select %s", configLocation, env)
+	}
+
 	err = json.Unmarshal(configBytes, &config)
 	if err != nil {
 		fmt.Printf("Unable to parse configuration file: %s\n", err)
@@ -109,6 +165,33 @@ func tryConfig(configLocation string) (Configuration, error) {
 	return config, nil
 }
 
+// Redacted returns a copy of the Configuration that is safe to print
+func (c Configuration) Redacted() Configuration {
+	if c.Password != "" {
+		c.Password = redacted
+	}
+	if len(c.Headers) > 0 {
+		headers := make(map[string]string, len(c.Headers))
+		for k, v := range c.Headers {
+			headers[k] = RedactHeader(k, v)
+		}
+		c.Headers = headers
+	}
+	if c.ProxyURL != "" {
+		if u, err := url.Parse(c.ProxyURL); err == nil && u.User != nil {
+			u.User = url.User(u.User.Username())
+			c.ProxyURL = u.String()
+		}
+	}
+	return c
+}
+
+// String implements fmt.Stringer so that logging a Configuration never shows the password
+func (c Configuration) String() string {
+	type plain Configuration
+	return fmt.Sprintf("%+v", plain(c.Redacted()))
+}
+
 func userHomeDir() string {
 	if runtime.GOOS == "windows" {
 		home := os.Getenv("HOMEDRIVE") + os.Getenv("HOMEPATH")
@@ -126,43 +209,85 @@ type Auth struct {
 	Password string `json:"password"`
 }
 
-// LoginToCM logs in to the API Platform
+// LoginToCM logs in to the API Platform, reusing a cached session
+// for the configured URL and email while its auth token is still valid
 func LoginToCM(config Configuration, debug bool) (*http.Client, UserInfo, error) {
 	var u UserInfo
 
-	// Login
-	if debug {
-		log.Println("Logging in...")
+	transport, err := newTransport(config, debug)
+	if err != nil {
+		return nil, u, err
 	}
-	client := &http.Client{}
-	var err error
 	jar, err := cookiejar.New(nil)
 	if err != nil {
-		log.Fatalln(err)
+		return nil, u, err
+	}
+	client := &http.Client{Jar: jar}
+	client.Transport = &sessionTransport{
+		base:   transport,
+		config: config,
+		jar:    jar,
+		debug:  debug,
+	}
+
+	if !config.sessionCache() {
+		if debug {
+			log.Println("Session cache not used when recording or replaying")
+		}
+	} else if s, ok := loadSession(config, debug); ok {
+		if err = s.restore(jar); err == nil {
+			if debug {
+				log.Println("Using cached session, valid until", s.ValidUntil())
+			}
+			return client, s.UserInfo, nil
+		}
+	}
+
+	u, err = login(client, config, debug)
+	if err != nil {
 		return client, u, err
 	}
-	client.Jar = jar
 
+	return client, u, nil
+}
+
+// login POSTs the configured credentials to CM and caches the resulting session
+func login(client *http.Client, config Configuration, debug bool) (UserInfo, error) {
+	var u UserInfo
+
+	// Login
 	if debug {
+		log.Println("Logging in...")
 		log.Println(config)
 	}
 	loginURI := config.URL + "/api/login"
-	auth := Auth{config.Email, config.Password}
+	var err error
+	password := scrubbed
+	if config.ReplayDir == "" {
+		// a replay answers the recorded login, which never holds the password
+		password, err = resolvePassword(config)
+		if err != nil {
+			return u, err
+		}
+	}
+	auth := Auth{config.Email, password}
 	buf, err := json.Marshal(auth)
 	if err != nil {
-		log.Fatalln(err)
-		return client, u, err
+		return u, err
 	}
 	req, err := http.NewRequest("POST", loginURI, bytes.NewReader(buf))
+	if err != nil {
+		return u, err
+	}
 	req.Header.Add("Accept", "application/json")
 	req.Header.Add("Content-Type", "application/json")
 
 	resp, err := client.Do(req)
 	if err != nil {
-		log.Fatalln(err)
-		return client, u, err
+		return u, err
 	}
-	if resp.StatusCode != 200 || debug {
+	defer resp.Body.Close()
+	if debug {
 		log.Printf("Login %s", resp.Status)
 	}
 
@@ -173,6 +298,13 @@ func LoginToCM(config Configuration, debug bool) (*http.Client, UserInfo, error)
 			log.Println("Can't get UserInfo")
 		}
 	}
+	if resp.StatusCode != 200 {
+		fault := cm.NewFaultError(resp, bodyBytes)
+		if fault == nil {
+			fault = &cm.FaultError{Method: req.Method, URL: loginURI, StatusCode: resp.StatusCode, Status: resp.Status}
+		}
+		return u, &LoginError{URL: config.URL, Email: config.Email, Fault: fault}
+	}
 	err = json.Unmarshal(bodyBytes, &u)
 	if err != nil {
 		if debug {
@@ -185,7 +317,32 @@ func LoginToCM(config Configuration, debug bool) (*http.Client, UserInfo, error)
 		DebugResponseHeader(resp)
 	}
 
-	return client, u, nil
+	if config.sessionCache() {
+		saveSession(config, client.Jar, u, debug)
+	}
+
+	return u, nil
+}
+
+// LoginError is returned when CM doesn't accept the configured credentials
+type LoginError struct {
+	URL   string
+	Email string
+	Fault *cm.FaultError
+}
+
+// Error implements the error interface
+func (e *LoginError) Error() string {
+	msg := fmt.Sprintf("Unable to log in to %s as %s: %s", e.URL, e.Email, e.Fault.Status)
+	if e.Fault.FaultMessage != "" {
+		msg += ": " + e.Fault.FaultMessage
+	}
+	return msg
+}
+
+// Unwrap returns CM's response to the login
+func (e *LoginError) Unwrap() error {
+	return e.Fault
 }
 
 // AddCsrfHeader checks to see if cookie jar has Csrf and adds it as a header
@@ -205,7 +362,7 @@ func DebugResponseHeader(resp *http.Response) {
 	log.Println(">>> DEBUG >>>")
 	for k, v := range resp.Header {
 		for _, h := range v {
-			log.Printf("%s : %s", k, h)
+			log.Printf("%s : %s", k, RedactHeader(k, h))
 		}
 	}
 	log.Println("<<< DEBUG <<<")
@@ -218,7 +375,7 @@ func DebugRequestHeader(req *http.Request) {
 	log.Println(">>> DEBUG >>>")
 	for k, v := range req.Header {
 		for _, h := range v {
-			log.Printf("%s : %s", k, h)
+			log.Printf("%s : %s", k, RedactHeader(k, h))
 		}
 	}
 	log.Println("<<< DEBUG <<<")
@@ -0,0 +1,106 @@
+package control
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+const (
+	// PasswordEnvVar is the default environment variable holding the CM password
+	PasswordEnvVar = "ATMOTOOL_PASSWORD"
+	redacted       = "********"
+)
+
+// resolvePassword determines the CM password, in order from the password
+// environment variable, the configured passwordCommand, the configuration
+// file, and finally an interactive prompt
+func resolvePassword(config Configuration) (string, error) {
+	envVar := config.PasswordEnv
+	if envVar == "" {
+		envVar = PasswordEnvVar
+	}
+	if p := os.Getenv(envVar); p != "" {
+		return p, nil
+	}
+	if config.PasswordCommand != "" {
+		return runPasswordCommand(config.PasswordCommand)
+	}
+	if config.Password != "" {
+		return config.Password, nil
+	}
+	return promptPassword(config)
+}
+
+// runPasswordCommand runs a local helper and uses the first line of its output as the password
+func runPasswordCommand(command string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	var stdout bytes.Buffer
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("passwordCommand failed: %s", err)
+	}
+	p := strings.SplitN(stdout.String(), "\n", 2)[0]
+	p = strings.TrimRight(p, "\r")
+	if p == "" {
+		return "", errors.New("passwordCommand returned a blank password")
+	}
+	return p, nil
+}
+
+// promptPassword asks for the password on the terminal, without echo
+func promptPassword(config Configuration) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", fmt.Errorf("Missing or blank password; set password, passwordCommand or %s", PasswordEnvVar)
+	}
+	fmt.Fprintf(os.Stderr, "Password for %s at %s: ", config.Email, config.URL)
+	b, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// RedactHeader returns a header value that is safe to log; cookies, CSRF
+// tokens and authorization values are masked
+func RedactHeader(name string, value string) string {
+	switch n := strings.ToLower(name); {
+	case n == "cookie":
+		return redactCookies(value, true)
+	case n == "set-cookie":
+		return redactCookies(value, false)
+	case n == "authorization", n == "proxy-authorization", strings.HasPrefix(n, "x-csrf-token"):
+		return redacted
+	}
+	return value
+}
+
+// redactCookies masks the values of name=value cookie pairs; for Set-Cookie
+// only the first pair is a cookie, the rest are attributes
+func redactCookies(value string, all bool) string {
+	pairs := strings.Split(value, ";")
+	for i, pair := range pairs {
+		if i > 0 && !all {
+			break
+		}
+		if eq := strings.Index(pair, "="); eq > -1 {
+			pairs[i] = pair[:eq+1] + redacted
+		}
+	}
+	return strings.Join(pairs, ";")
+}
@@ -2,31 +2,102 @@ package control
 
 import (
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"regexp"
+	"sort"
 	"strings"
+	"unicode/utf8"
 )
 
 // CURLThis takes an http.Client and http.Request and outputs the
-// equivalent cURL command, to be used elsewhere.
+// equivalent cURL command, to be used elsewhere. Cookie and CSRF token
+// values are redacted, so the output is safe to log.
 func CURLThis(client *http.Client, req *http.Request) string {
-	curl := "curl -v"
-	curl += fmt.Sprintf(" -X %s", req.Method)
-	if req.Method == "POST" {
-		curl += fmt.Sprintf(" --data %s", "[data]")
+	return curlCommand(req, client.Jar.Cookies(req.URL), true)
+}
+
+// uploadKey is the request context key for the file of an upload request
+type uploadKey struct{}
+
+// formFile is a local file sent as a multipart form field
+type formFile struct {
+	field string
+	path  string
+}
+
+// curlCommand returns a curl command line equivalent to req, with its headers,
+// the given cookies and its body; a file upload is given as a -F part. With
+// redact, cookie, CSRF and credential values are masked.
+func curlCommand(req *http.Request, cookies []*http.Cookie, redact bool) string {
+	upload, isUpload := req.Context().Value(uploadKey{}).(formFile)
+
+	args := []string{"curl", "-X", req.Method}
+
+	var names []string
+	for k := range req.Header {
+		names = append(names, k)
 	}
-	for _, v := range client.Jar.Cookies(req.URL) {
-		if strings.HasPrefix(v.Name, "Csrf-Token") {
-			curl += fmt.Sprintf(" -H \"X-%s: %s\"", v.Name, v.Value)
-		} else {
-			curl += fmt.Sprintf(" --cookie \"%s\"", v)
+	sort.Strings(names)
+	for _, k := range names {
+		if isUpload && k == "Content-Type" {
+			// curl sets the multipart boundary itself
+			continue
+		}
+		for _, v := range req.Header[k] {
+			if redact {
+				v = RedactHeader(k, v)
+			}
+			args = append(args, "-H", shellQuote(k+": "+v))
+		}
+	}
+
+	if len(cookies) > 0 {
+		var pairs []string
+		for _, c := range cookies {
+			v := c.Value
+			if redact {
+				v = redacted
+			}
+			pairs = append(pairs, c.Name+"="+v)
 		}
+		args = append(args, "--cookie", shellQuote(strings.Join(pairs, "; ")))
 	}
-	for k, v := range req.Header {
-		for _, hv := range v {
-			curl += fmt.Sprintf(" -H \"%s:%v\"", k, hv)
+
+	if isUpload {
+		args = append(args, "-F", shellQuote(upload.field+"=@"+upload.path))
+	} else if body := requestBody(req); len(body) > 0 {
+		if utf8.Valid(body) {
+			args = append(args, "--data-binary", shellQuote(string(body)))
+		} else {
+			args = append(args, "--data-binary", shellQuote(fmt.Sprintf("[%d bytes of binary data]", len(body))))
 		}
 	}
-	curl += fmt.Sprintf(" %s", req.URL)
 
-	return curl
+	args = append(args, shellQuote(req.URL.String()))
+	return strings.Join(args, " ")
+}
+
+// requestBody returns a copy of the body of req, without consuming it
+func requestBody(req *http.Request) []byte {
+	if req.Body == nil || req.GetBody == nil {
+		return nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil
+	}
+	defer body.Close()
+	b, _ := ioutil.ReadAll(body)
+	return b
+}
+
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// shellQuote quotes s for a POSIX shell
+func shellQuote(s string) string {
+	if shellSafe.MatchString(s) {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
 }
@@ -0,0 +1,110 @@
+package control_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ghchinoy/atmotool/control"
+)
+
+// captureStdout returns what f writes to standard output
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	out := make(chan string)
+	go func() {
+		var buf bytes.Buffer
+		io.Copy(&buf, r)
+		out <- buf.String()
+	}()
+	defer func() {
+		os.Stdout = stdout
+	}()
+	f()
+	w.Close()
+	return <-out
+}
+
+// TestDryRun makes changes with --dry-run, which the fake CM mustn't receive
+func TestDryRun(t *testing.T) {
+	s, _ := newClient(t)
+	s.PutFile("/content/home/landing/index.htm", []byte("<h1>hi</h1>"))
+	p := filepath.Join(t.TempDir(), "custom.less")
+	if err := ioutil.WriteFile(p, []byte("a {}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := s.Config()
+	config.DryRun = true
+	before := len(s.Requests())
+
+	out := captureStdout(t, func() {
+		client, err := control.NewClient(config, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := client.Get("/content/home/landing", nil); err != nil {
+			t.Error(err)
+		}
+		if err := client.Delete("/content/home/landing/index.htm", nil); err != nil {
+			t.Error(err)
+		}
+		if err := client.Upload("/resources/theme/default/less?unpack=false", "File", p, nil); err != nil {
+			t.Error(err)
+		}
+		form := url.Values{"theme": {"default"}}.Encode()
+		if err := client.Post("/resources/branding/generatestyles", "application/x-www-form-urlencoded", []byte(form), nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	for _, r := range s.Requests()[before:] {
+		if !strings.HasPrefix(r, "GET ") && r != "POST /api/login" {
+			t.Errorf("the fake CM received %s in a dry run", r)
+		}
+	}
+	if _, ok := s.File("/content/home/landing/index.htm"); !ok {
+		t.Error("index.htm was deleted in a dry run")
+	}
+	if _, ok := s.File("/resources/theme/default/less/custom.less"); ok {
+		t.Error("custom.less was uploaded in a dry run")
+	}
+	if got := s.Rebuilds(); len(got) != 0 {
+		t.Errorf("styles were rebuilt in a dry run: %v", got)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	want := []string{
+		"curl -X DELETE ",
+		"curl -X POST ",
+		"curl -X POST ",
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("dry run output =\n%s", out)
+	}
+	for i, line := range lines {
+		if !strings.HasPrefix(line, want[i]) || !strings.Contains(line, "X-Csrf-Token_") || !strings.Contains(line, "AtmoAuthToken_") {
+			t.Errorf("curl command %d = %s", i+1, line)
+		}
+	}
+	checks := []string{
+		s.URL + "/content/home/landing/index.htm",
+		"-F File=@" + p,
+		"--data-binary theme=default " + s.URL + "/resources/branding/generatestyles",
+	}
+	for i, check := range checks {
+		if !strings.Contains(lines[i], check) {
+			t.Errorf("curl command %d = %s, want %s in it", i+1, lines[i], check)
+		}
+	}
+}
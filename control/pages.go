@@ -0,0 +1,106 @@
+package control
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/ghchinoy/atmotool/cm"
+	"golang.org/x/term"
+)
+
+const defaultPageSize = 100
+
+// GetAll pages through a CM listing, such as /api/search or /api/apis, with
+// its start and count parameters. It returns the items, up to the configured
+// Limit, and the total CM has; the total is -1 if the Limit stopped the walk
+// before the end and CM didn't report it.
+func (c *Client) GetAll(path string) ([]cm.Item, int, error) {
+	u, err := url.Parse(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	query := u.Query()
+
+	pageSize := c.Config.PageSize
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	limit := c.Config.Limit
+	if limit > 0 && limit < pageSize {
+		pageSize = limit
+	}
+
+	var items []cm.Item
+	var firstGUID string
+	total := -1
+	pages := 0
+	complete := false
+	for start := 0; ; {
+		query.Set("start", strconv.Itoa(start))
+		query.Set("count", strconv.Itoa(pageSize))
+		u.RawQuery = query.Encode()
+
+		var page cm.ApisResponse
+		if err = c.Get(u.String(), &page); err != nil {
+			c.endProgress(pages)
+			return items, total, err
+		}
+		got := page.Channel.Items
+		if page.Channel.TotalResults > 0 {
+			total = page.Channel.TotalResults
+		}
+		if len(got) > pageSize || (len(got) > 0 && got[0].Guid.Value != "" && got[0].Guid.Value == firstGUID) {
+			// CM ignored start and count, so the first page had everything
+			if len(got) > pageSize {
+				items = got
+			}
+			complete = true
+			break
+		}
+		if len(got) > 0 {
+			firstGUID = got[0].Guid.Value
+		}
+		items = append(items, got...)
+		start += len(got)
+		pages++
+		c.progress(pages, len(items), total)
+
+		if len(got) < pageSize || (total >= 0 && start >= total) {
+			complete = true
+			break
+		}
+		if limit > 0 && len(items) >= limit {
+			break
+		}
+	}
+	c.endProgress(pages)
+
+	if complete && total < len(items) {
+		total = len(items)
+	}
+	if limit > 0 && len(items) > limit {
+		items = items[:limit]
+	}
+	return items, total, nil
+}
+
+// progress shows how far a listing has got on a terminal's stderr, from the second page on
+func (c *Client) progress(pages int, fetched int, total int) {
+	if pages < 2 || c.debug || !term.IsTerminal(int(os.Stderr.Fd())) {
+		return
+	}
+	if total >= 0 {
+		fmt.Fprintf(os.Stderr, "\rFetched %d of %d", fetched, total)
+	} else {
+		fmt.Fprintf(os.Stderr, "\rFetched %d", fetched)
+	}
+}
+
+func (c *Client) endProgress(pages int) {
+	if pages < 2 || c.debug || !term.IsTerminal(int(os.Stderr.Fd())) {
+		return
+	}
+	fmt.Fprintln(os.Stderr)
+}
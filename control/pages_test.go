@@ -0,0 +1,85 @@
+package control_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ghchinoy/atmotool/cm"
+	"github.com/ghchinoy/atmotool/control"
+)
+
+func TestGetAll(t *testing.T) {
+	s, client := newClient(t)
+	for i := 0; i < 250; i++ {
+		s.AddAPI(fmt.Sprintf("api%03d", i), "", "", "")
+	}
+
+	tests := []struct {
+		pageSize  int
+		limit     int
+		items     int
+		total     int
+		listPages int
+	}{
+		{0, 0, 250, 250, 3},
+		{100, 0, 250, 250, 3},
+		{50, 0, 250, 250, 5},
+		{100, 120, 120, 250, 2},
+		{100, 30, 30, 250, 1},
+		{250, 0, 250, 250, 1},
+		{1000, 0, 250, 250, 1},
+	}
+	for _, tt := range tests {
+		before := len(s.Requests())
+		c := *client
+		c.Config.PageSize, c.Config.Limit = tt.pageSize, tt.limit
+		items, total, err := c.GetAll("/api/apis")
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages := len(s.Requests()) - before
+		if len(items) != tt.items || total != tt.total || pages != tt.listPages {
+			t.Errorf("page size %d, limit %d: %d items of %d in %d pages, want %d of %d in %d",
+				tt.pageSize, tt.limit, len(items), total, pages, tt.items, tt.total, tt.listPages)
+		}
+		seen := map[string]bool{}
+		for _, v := range items {
+			if seen[v.Guid.Value] {
+				t.Errorf("page size %d, limit %d: %s listed twice", tt.pageSize, tt.limit, v.Title)
+			}
+			seen[v.Guid.Value] = true
+		}
+	}
+}
+
+// TestGetAllUnpaged is a CM that ignores start and count, answering
+// everything, without a total, every time
+func TestGetAllUnpaged(t *testing.T) {
+	var list cm.ApisResponse
+	for i := 0; i < 30; i++ {
+		list.Channel.Items = append(list.Channel.Items, cm.Item{Title: fmt.Sprint(i), Guid: cm.Guid{Value: fmt.Sprintf("id%d", i)}})
+	}
+	var requests int
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		json.NewEncoder(w).Encode(list)
+	}))
+	defer s.Close()
+	jar, _ := cookiejar.New(nil)
+
+	for _, pageSize := range []int{10, 30, 100} {
+		requests = 0
+		client := &control.Client{Config: control.Configuration{URL: s.URL, PageSize: pageSize}, HTTP: &http.Client{Jar: jar}}
+		items, total, err := client.GetAll("/api/apis")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items) != 30 || total != 30 || requests > 2 {
+			t.Errorf("page size %d: %d items of %d in %d requests, want 30 of 30", pageSize, len(items), total, requests)
+		}
+	}
+}
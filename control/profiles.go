@@ -0,0 +1,90 @@
+package control
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Profiles is a configuration file holding several named Configurations,
+// one of which is the current profile
+type Profiles struct {
+	Current  string                   `json:"current"`
+	Profiles map[string]Configuration `json:"profiles"`
+}
+
+// DefaultProfilesLocation is the profiles file used when no --config is given
+func DefaultProfilesLocation() string {
+	return filepath.Join(userHomeDir(), ".akana", "config")
+}
+
+// LoadProfiles reads a profiles file
+func LoadProfiles(location string) (Profiles, error) {
+	var profiles Profiles
+	b, err := ioutil.ReadFile(location)
+	if err != nil {
+		return profiles, err
+	}
+	err = json.Unmarshal(b, &profiles)
+	if err != nil {
+		return profiles, fmt.Errorf("Unable to parse profiles file %s: %s", location, err)
+	}
+	if len(profiles.Profiles) == 0 {
+		return profiles, fmt.Errorf("No profiles found in %s", location)
+	}
+	return profiles, nil
+}
+
+// Save writes the profiles file, readable only by the current user
+func (p Profiles) Save(location string) error {
+	b, err := json.MarshalIndent(p, "", "    ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(location), 0700)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(location, b, 0600)
+}
+
+// Names returns the sorted profile names
+func (p Profiles) Names() []string {
+	var names []string
+	for k := range p.Profiles {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Select returns the named profile, or the current one if name is blank
+func (p Profiles) Select(name string) (Configuration, error) {
+	if name == "" {
+		name = p.Current
+	}
+	if name == "" {
+		return Configuration{}, fmt.Errorf("No current profile set, choose one of %v", p.Names())
+	}
+	config, ok := p.Profiles[name]
+	if !ok {
+		return config, fmt.Errorf("Unknown profile %s, choose one of %v", name, p.Names())
+	}
+	return config, nil
+}
+
+// UseProfile makes name the current profile of the profiles file
+func UseProfile(location string, name string) error {
+	profiles, err := LoadProfiles(location)
+	if err != nil {
+		return err
+	}
+	if _, err = profiles.Select(name); err != nil {
+		return err
+	}
+	profiles.Current = name
+	return profiles.Save(location)
+}
@@ -0,0 +1,117 @@
+package control_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ghchinoy/atmotool/control"
+)
+
+// writeProfiles writes a profiles file to ~/.akana/config in a temporary home
+func writeProfiles(t *testing.T, content string) string {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	location := control.DefaultProfilesLocation()
+	if err := os.MkdirAll(filepath.Dir(location), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(location, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return location
+}
+
+const profiles = `{
+	"current": "dev",
+	"profiles": {
+		"dev": {"url": "https://dev.example.com", "email": "dev@example.com"},
+		"prod": {"url": "https://prod.example.com", "email": "ops@example.com"}
+	}
+}`
+
+func TestProfileSelection(t *testing.T) {
+	writeProfiles(t, profiles)
+
+	tests := []struct {
+		env  string
+		want string
+		err  string
+	}{
+		{"", "https://dev.example.com", ""},
+		{"prod", "https://prod.example.com", ""},
+		{"staging", "", "Unknown profile staging, choose one of [dev prod]"},
+	}
+	for _, tt := range tests {
+		config, err := control.InitializeConfiguration("", tt.env, false)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("--env %q: %v, want an error with %q", tt.env, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || config.URL != tt.want {
+			t.Errorf("--env %q: %s, %v, want %s", tt.env, config.URL, err, tt.want)
+		}
+	}
+}
+
+func TestProfileSelectionFromConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	location := filepath.Join(dir, "team.conf")
+	if err := ioutil.WriteFile(location, []byte(profiles), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if config, err := control.InitializeConfiguration(location, "prod", false); err != nil || config.Email != "ops@example.com" {
+		t.Errorf("--config with --env = %+v, %v", config, err)
+	}
+
+	// a single configuration has no profiles to choose from
+	single := filepath.Join(dir, "local.conf")
+	if err := ioutil.WriteFile(single, []byte(`{"url": "https://cm.example.com"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if config, err := control.InitializeConfiguration(single, "", false); err != nil || config.URL != "https://cm.example.com" {
+		t.Errorf("single configuration = %+v, %v", config, err)
+	}
+	if _, err := control.InitializeConfiguration(single, "prod", false); err == nil || !strings.Contains(err.Error(), "has no profiles") {
+		t.Errorf("--env with a single configuration = %v", err)
+	}
+}
+
+func TestNoCurrentProfile(t *testing.T) {
+	writeProfiles(t, `{"profiles": {"dev": {"url": "https://dev.example.com"}}}`)
+	if _, err := control.InitializeConfiguration("", "", false); err == nil || !strings.Contains(err.Error(), "No current profile") {
+		t.Errorf("no current profile = %v", err)
+	}
+	if config, err := control.InitializeConfiguration("", "dev", false); err != nil || config.URL != "https://dev.example.com" {
+		t.Errorf("--env dev = %+v, %v", config, err)
+	}
+}
+
+func TestUseProfile(t *testing.T) {
+	location := writeProfiles(t, profiles)
+
+	if err := control.UseProfile(location, "prod"); err != nil {
+		t.Fatal(err)
+	}
+	config, err := control.InitializeConfiguration("", "", false)
+	if err != nil || config.URL != "https://prod.example.com" {
+		t.Errorf("after config use prod: %s, %v", config.URL, err)
+	}
+	info, err := os.Stat(location)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("profiles file mode = %v, %v, want 0600", info.Mode().Perm(), err)
+	}
+
+	if err := control.UseProfile(location, "staging"); err == nil {
+		t.Error("config use of an unknown profile succeeded")
+	}
+	p, err := control.LoadProfiles(location)
+	if err != nil || p.Current != "prod" || len(p.Profiles) != 2 {
+		t.Errorf("profiles after config use staging = %+v, %v", p, err)
+	}
+}
@@ -0,0 +1,247 @@
+package control
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+const scrubbed = "scrubbed"
+
+// Exchange is a recorded CM request and its response, as stored in a fixture file
+type Exchange struct {
+	Request  RecordedMessage `json:"request"`
+	Response RecordedMessage `json:"response"`
+}
+
+// RecordedMessage is one side of an Exchange. Text bodies are kept in Body,
+// binary ones, such as zips, base64 encoded in BodyBase64.
+type RecordedMessage struct {
+	Method     string      `json:"method,omitempty"`
+	URL        string      `json:"url,omitempty"`
+	StatusCode int         `json:"statusCode,omitempty"`
+	Status     string      `json:"status,omitempty"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+	BodyBase64 string      `json:"bodyBase64,omitempty"`
+}
+
+func (m *RecordedMessage) setBody(b []byte) {
+	if utf8.Valid(b) {
+		m.Body = string(b)
+	} else {
+		m.BodyBase64 = base64.StdEncoding.EncodeToString(b)
+	}
+}
+
+func (m RecordedMessage) body() ([]byte, error) {
+	if m.BodyBase64 != "" {
+		return base64.StdEncoding.DecodeString(m.BodyBase64)
+	}
+	return []byte(m.Body), nil
+}
+
+// scrubHeader masks cookies, CSRF tokens and credentials, keeping cookie names
+// so that a replayed session still finds its Csrf-Token cookie
+func scrubHeader(h http.Header) http.Header {
+	out := make(http.Header, len(h))
+	for k, vs := range h {
+		for _, v := range vs {
+			v = RedactHeader(k, v)
+			out[k] = append(out[k], strings.Replace(v, redacted, scrubbed, -1))
+		}
+	}
+	return out
+}
+
+// scrubLogin removes the password from a recorded /api/login body
+func scrubLogin(b []byte) []byte {
+	var auth Auth
+	if err := json.Unmarshal(b, &auth); err != nil {
+		return b
+	}
+	auth.Password = scrubbed
+	out, err := json.Marshal(auth)
+	if err != nil {
+		return b
+	}
+	return out
+}
+
+var unsafeFilename = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// recordTransport saves every request and response it passes to fixture files in dir
+type recordTransport struct {
+	base http.RoundTripper
+	dir  string
+	mu   sync.Mutex
+	seq  int
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *recordTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	var err error
+	if req.Body != nil {
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			reqBody, err = ioutil.ReadAll(body)
+			body.Close()
+		} else {
+			reqBody, err = ioutil.ReadAll(req.Body)
+			req.Body.Close()
+			req.Body = ioutil.NopCloser(bytes.NewReader(reqBody))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+
+	if isLoginRequest(req) {
+		reqBody = scrubLogin(reqBody)
+	}
+	x := Exchange{
+		Request: RecordedMessage{
+			Method: req.Method,
+			URL:    req.URL.RequestURI(),
+			Header: scrubHeader(req.Header),
+		},
+		Response: RecordedMessage{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Header:     scrubHeader(resp.Header),
+		},
+	}
+	x.Request.setBody(reqBody)
+	x.Response.setBody(respBody)
+
+	return resp, t.save(x)
+}
+
+func (t *recordTransport) save(x Exchange) error {
+	b, err := json.MarshalIndent(x, "", "  ")
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	t.seq++
+	name := fmt.Sprintf("%04d-%s-%s", t.seq, x.Request.Method, unsafeFilename.ReplaceAllString(strings.SplitN(x.Request.URL, "?", 2)[0], "_"))
+	t.mu.Unlock()
+	if len(name) > 100 {
+		name = name[:100]
+	}
+	return ioutil.WriteFile(filepath.Join(t.dir, name+".json"), b, 0600)
+}
+
+// replayTransport answers requests from fixture files instead of the network.
+// Exchanges are matched on method, path and query, in recorded order; once a
+// request's exchanges are used up the last one is served again.
+type replayTransport struct {
+	dir       string
+	mu        sync.Mutex
+	exchanges map[string][]Exchange
+}
+
+func newReplayTransport(dir string) (*replayTransport, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("No recorded exchanges found in %s", dir)
+	}
+	sort.Strings(files)
+	t := &replayTransport{dir: dir, exchanges: make(map[string][]Exchange)}
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		var x Exchange
+		if err = json.Unmarshal(b, &x); err != nil {
+			return nil, fmt.Errorf("Unable to parse fixture %s: %s", f, err)
+		}
+		key := x.Request.Method + " " + x.Request.URL
+		t.exchanges[key] = append(t.exchanges[key], x)
+	}
+	return t, nil
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	key := req.Method + " " + req.URL.RequestURI()
+
+	t.mu.Lock()
+	queue := t.exchanges[key]
+	if len(queue) == 0 {
+		t.mu.Unlock()
+		return nil, fmt.Errorf("No recorded response for %s in %s", key, t.dir)
+	}
+	x := queue[0]
+	if len(queue) > 1 {
+		t.exchanges[key] = queue[1:]
+	}
+	t.mu.Unlock()
+
+	body, err := x.Response.body()
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		Status:        x.Response.Status,
+		StatusCode:    x.Response.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        x.Response.Header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// wrapRecordReplay applies the record or replay mode of the Configuration to base
+func wrapRecordReplay(config Configuration, base http.RoundTripper) (http.RoundTripper, error) {
+	if config.ReplayDir != "" {
+		return newReplayTransport(config.ReplayDir)
+	}
+	if config.RecordDir != "" {
+		if err := os.MkdirAll(config.RecordDir, 0700); err != nil {
+			return nil, err
+		}
+		// continue numbering after earlier recordings in the same directory
+		existing, err := filepath.Glob(filepath.Join(config.RecordDir, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		return &recordTransport{base: base, dir: config.RecordDir, seq: len(existing)}, nil
+	}
+	return base, nil
+}
@@ -0,0 +1,107 @@
+package control_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ghchinoy/atmotool/cm"
+	"github.com/ghchinoy/atmotool/cmtest"
+	"github.com/ghchinoy/atmotool/control"
+)
+
+// TestRecordReplay records exchanges with a fake CM, checks the fixtures
+// keep no secrets, and replays them once the fake CM is gone
+func TestRecordReplay(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	s := cmtest.NewServer()
+	defer s.Close()
+	s.Password = "s3cret-pw"
+	s.PutFile("/content/home/landing/index.htm", []byte("<h1>hi</h1>"))
+	s.PutFile("/content/home/landing/logo.png", []byte("\x89PNG\x00\xff"))
+
+	dir := t.TempDir()
+	config := s.Config()
+	config.RecordDir = dir
+	config.Headers = map[string]string{"Authorization": "Bearer t0ken"}
+	client, err := control.NewClient(config, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var recorded cm.ApisResponse
+	if err := client.Get("/content/home/landing", &recorded); err != nil {
+		t.Fatal(err)
+	}
+	var zip bytes.Buffer
+	if _, err := client.Download("/content/home/landing?download=true&Zip=true", &zip); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Delete("/content/home/landing/logo.png", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// no password, token, session cookie or CSRF token in the fixtures
+	u, _ := url.Parse(s.URL)
+	secrets := []string{"s3cret-pw", "t0ken"}
+	for _, c := range client.HTTP.Jar.Cookies(u) {
+		secrets = append(secrets, c.Value)
+	}
+	fixtures, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(fixtures) != 4 {
+		t.Errorf("recorded %d exchanges, want the login and 3 calls", len(fixtures))
+	}
+	for _, f := range fixtures {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, secret := range secrets {
+			if strings.Contains(string(b), secret) {
+				t.Errorf("%s contains the secret %q", filepath.Base(f), secret)
+			}
+		}
+	}
+	s.Close()
+
+	config = control.Configuration{URL: s.URL, Email: s.Email, Password: "s3cret-pw", ReplayDir: dir, Retries: -1}
+	client, err = control.NewClient(config, false)
+	if err != nil {
+		t.Fatalf("replayed login: %v", err)
+	}
+	var replayed cm.ApisResponse
+	if err := client.Get("/content/home/landing", &replayed); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(replayed, recorded) {
+		t.Errorf("replayed listing = %+v, recorded %+v", replayed, recorded)
+	}
+	var replayedZip bytes.Buffer
+	if _, err := client.Download("/content/home/landing?download=true&Zip=true", &replayedZip); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(replayedZip.Bytes(), zip.Bytes()) {
+		t.Error("replayed zip differs from the recorded one")
+	}
+	if err := client.Delete("/content/home/landing/logo.png", nil); err != nil {
+		t.Error(err)
+	}
+
+	// a request that wasn't recorded fails, rather than going to the network
+	err = client.Get("/content/home/other", nil)
+	if err == nil || !strings.Contains(err.Error(), "No recorded response for GET /content/home/other") {
+		t.Errorf("unrecorded request = %v", err)
+	}
+}
+
+func TestReplayWithoutFixtures(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	config := control.Configuration{URL: "http://cm.example.com", ReplayDir: t.TempDir()}
+	_, err := control.NewClient(config, false)
+	if err == nil || !strings.Contains(err.Error(), "No recorded exchanges found") {
+		t.Errorf("replay of an empty directory = %v", err)
+	}
+}
@@ -0,0 +1,251 @@
+package control
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// sessionDefaultLifetime is used when CM does not report a parseable authTokenValidUntil
+	sessionDefaultLifetime = 30 * time.Minute
+)
+
+// Session is a cached login to a Community Manager, keyed by URL and email
+type Session struct {
+	URL      string         `json:"url"`
+	Email    string         `json:"email"`
+	Cookies  []*http.Cookie `json:"cookies"`
+	UserInfo UserInfo       `json:"userInfo"`
+	SavedAt  time.Time      `json:"savedAt"`
+}
+
+// ValidUntil returns the time at which the cached session's auth token expires
+func (s Session) ValidUntil() time.Time {
+	if t, ok := parseTokenExpiry(s.UserInfo.AuthTokenValidUntil); ok {
+		return t
+	}
+	return s.SavedAt.Add(sessionDefaultLifetime)
+}
+
+// Valid reports whether the cached session can still be used
+func (s Session) Valid() bool {
+	return len(s.Cookies) > 0 && time.Now().Before(s.ValidUntil())
+}
+
+// parseTokenExpiry understands the timestamp formats CM uses for authTokenValidUntil
+func parseTokenExpiry(v string) (time.Time, bool) {
+	if v == "" {
+		return time.Time{}, false
+	}
+	if ms, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(0, ms*int64(time.Millisecond)), true
+	}
+	layouts := []string{
+		time.RFC3339Nano,
+		"2006-01-02T15:04:05.000-0700",
+		"2006-01-02T15:04:05-0700",
+		time.RFC1123Z,
+		time.RFC1123,
+	}
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// sessionCache reports whether sessions may be cached; recordings must
+// contain the login and replays must not depend on a real session
+func (c Configuration) sessionCache() bool {
+	return c.RecordDir == "" && c.ReplayDir == ""
+}
+
+// sessionFile returns the location of the cached session for a configuration
+func sessionFile(config Configuration) string {
+	sum := sha1.Sum([]byte(strings.TrimSuffix(config.URL, "/") + "|" + config.Email))
+	return filepath.Join(userHomeDir(), ".akana", "sessions", hex.EncodeToString(sum[:])+".json")
+}
+
+// loadSession reads a cached session, returning false if none exists or it has expired
+func loadSession(config Configuration, debug bool) (Session, bool) {
+	var s Session
+	b, err := ioutil.ReadFile(sessionFile(config))
+	if err != nil {
+		return s, false
+	}
+	if err = json.Unmarshal(b, &s); err != nil {
+		if debug {
+			log.Println("Ignoring unreadable session cache:", err)
+		}
+		return s, false
+	}
+	if !s.Valid() {
+		if debug {
+			log.Println("Cached session expired at", s.ValidUntil())
+		}
+		return s, false
+	}
+	return s, true
+}
+
+// saveSession writes the cookies currently held in jar to the session cache
+func saveSession(config Configuration, jar http.CookieJar, u UserInfo, debug bool) {
+	cmURL, err := url.Parse(config.URL)
+	if err != nil {
+		return
+	}
+	s := Session{
+		URL:      config.URL,
+		Email:    config.Email,
+		Cookies:  jar.Cookies(cmURL),
+		UserInfo: u,
+		SavedAt:  time.Now(),
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		return
+	}
+	fn := sessionFile(config)
+	if err = os.MkdirAll(filepath.Dir(fn), 0700); err == nil {
+		err = ioutil.WriteFile(fn, b, 0600)
+	}
+	if err != nil && debug {
+		log.Println("Unable to cache session:", err)
+	}
+}
+
+// restore places the cached cookies into jar
+func (s Session) restore(jar http.CookieJar) error {
+	cmURL, err := url.Parse(s.URL)
+	if err != nil {
+		return err
+	}
+	for _, c := range s.Cookies {
+		c.Path = "/"
+	}
+	jar.SetCookies(cmURL, s.Cookies)
+	return nil
+}
+
+// ClearSession removes the cached session for a configuration, if any
+func ClearSession(config Configuration) error {
+	err := os.Remove(sessionFile(config))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Logout forgets the cached CM session for the configured URL and email
+func Logout(config Configuration, debug bool) error {
+	if debug {
+		log.Println("Removing session cache", sessionFile(config))
+	}
+	return ClearSession(config)
+}
+
+// sessionTransport logs in again and retries a request once when CM answers 401
+type sessionTransport struct {
+	base   http.RoundTripper
+	config Configuration
+	jar    http.CookieJar
+	debug  bool
+	// mu serializes logging in again, so that concurrent requests rejected
+	// by the same expired session log in once
+	mu sync.Mutex
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *sessionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || isLoginRequest(req) {
+		return resp, err
+	}
+	if req.Body != nil && req.GetBody == nil {
+		// body already consumed and can't be replayed
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	t.mu.Lock()
+	if jarCookies(t.jar, req.URL) == req.Header.Get("Cookie") {
+		if t.debug {
+			log.Println("Session rejected by CM, logging in again")
+		}
+		if t.config.sessionCache() {
+			ClearSession(t.config)
+		}
+		_, err = login(&http.Client{Transport: t.base, Jar: t.jar}, t.config, t.debug)
+	} else if t.debug {
+		// a concurrent request has logged in again since this one was sent
+		log.Println("Session rejected by CM, retrying with the new session")
+	}
+	t.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	retry.Header.Del("Cookie")
+	for _, c := range t.jar.Cookies(req.URL) {
+		retry.AddCookie(c)
+	}
+	if hasCsrfHeader(req) {
+		setCsrfHeader(retry, t.jar)
+	}
+	return t.base.RoundTrip(retry)
+}
+
+// jarCookies returns the Cookie header of the jar's cookies for u, as a
+// client using the jar sends it
+func jarCookies(jar http.CookieJar, u *url.URL) string {
+	r := &http.Request{Header: make(http.Header)}
+	for _, c := range jar.Cookies(u) {
+		r.AddCookie(c)
+	}
+	return r.Header.Get("Cookie")
+}
+
+func isLoginRequest(req *http.Request) bool {
+	return strings.HasSuffix(req.URL.Path, "/api/login")
+}
+
+func hasCsrfHeader(req *http.Request) bool {
+	for k := range req.Header {
+		if strings.HasPrefix(k, "X-Csrf-Token") {
+			return true
+		}
+	}
+	return false
+}
+
+// setCsrfHeader replaces any CSRF header on req with the current jar value
+func setCsrfHeader(req *http.Request, jar http.CookieJar) {
+	for k := range req.Header {
+		if strings.HasPrefix(k, "X-Csrf-Token") {
+			req.Header.Del(k)
+		}
+	}
+	for _, v := range jar.Cookies(req.URL) {
+		if strings.HasPrefix(v.Name, "Csrf-Token") {
+			req.Header.Add("X-"+v.Name, v.Value)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package control_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/ghchinoy/atmotool/cm"
+)
+
+// TestLoginAgainOnceForConcurrent401s is many requests rejected by the same
+// expired session, as when cms list works through a tree
+func TestLoginAgainOnceForConcurrent401s(t *testing.T) {
+	s, client := newClient(t)
+	s.PutFile("/content/home/landing/index.htm", []byte("<h1>hi</h1>"))
+	s.ExpireSessions()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var listing cm.ApisResponse
+			errs <- client.Get("/content/home/landing", &listing)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if n := logins(s); n != 2 {
+		t.Errorf("logged in %d times, want once and once again", n)
+	}
+}
@@ -0,0 +1,366 @@
+package control
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultTimeout         = 60 * time.Second
+	defaultRetries         = 3
+	defaultRetryBackoff    = 500 * time.Millisecond
+	defaultRetryMaxBackoff = 10 * time.Second
+)
+
+// httpSettings are the timeout and retry settings of a Configuration, with defaults applied
+type httpSettings struct {
+	timeout         time.Duration
+	retries         int
+	retryBackoff    time.Duration
+	retryMaxBackoff time.Duration
+}
+
+// settings parses the timeout and retry settings of the Configuration
+func (c Configuration) settings() (httpSettings, error) {
+	s := httpSettings{
+		timeout:         defaultTimeout,
+		retries:         defaultRetries,
+		retryBackoff:    defaultRetryBackoff,
+		retryMaxBackoff: defaultRetryMaxBackoff,
+	}
+	var err error
+	if c.Timeout != "" {
+		if s.timeout, err = time.ParseDuration(c.Timeout); err != nil {
+			return s, fmt.Errorf("Invalid timeout %q: %s", c.Timeout, err)
+		}
+	}
+	if c.Retries < 0 {
+		s.retries = 0
+	} else if c.Retries > 0 {
+		s.retries = c.Retries
+	}
+	if c.RetryBackoff != "" {
+		if s.retryBackoff, err = time.ParseDuration(c.RetryBackoff); err != nil {
+			return s, fmt.Errorf("Invalid retryBackoff %q: %s", c.RetryBackoff, err)
+		}
+	}
+	if c.RetryMaxBackoff != "" {
+		if s.retryMaxBackoff, err = time.ParseDuration(c.RetryMaxBackoff); err != nil {
+			return s, fmt.Errorf("Invalid retryMaxBackoff %q: %s", c.RetryMaxBackoff, err)
+		}
+	}
+	return s, nil
+}
+
+// newTransport returns the http.RoundTripper used for every call to CM:
+// an http.Transport with the configured timeouts, TLS settings, proxy and
+// static headers, recording or replaced by a replay if requested, and holding
+// back changes in a dry run, wrapped to retry
+func newTransport(config Configuration, debug bool) (http.RoundTripper, error) {
+	s, err := config.settings()
+	if err != nil {
+		return nil, err
+	}
+
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	// the timeout bounds connecting, waiting for CM to respond and pauses in
+	// the response body, but not the whole transfer, so long uploads and
+	// downloads aren't cut off
+	if s.timeout > 0 {
+		base.DialContext = (&net.Dialer{Timeout: s.timeout, KeepAlive: 30 * time.Second}).DialContext
+		base.TLSHandshakeTimeout = s.timeout
+		base.ResponseHeaderTimeout = s.timeout
+	}
+
+	base.TLSClientConfig, err = config.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config.ProxyURL != "" {
+		proxy, err := url.Parse(config.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid proxyURL %q: %s", config.ProxyURL, err)
+		}
+		base.Proxy = http.ProxyURL(proxy)
+	}
+
+	var transport http.RoundTripper = base
+	if s.timeout > 0 {
+		transport = &idleTimeoutTransport{base: base, timeout: s.timeout}
+	}
+	transport, err = wrapRecordReplay(config, transport)
+	if err != nil {
+		return nil, err
+	}
+	if config.DryRun {
+		transport = &dryRunTransport{base: transport, out: os.Stdout}
+	}
+	if len(config.Headers) > 0 {
+		transport = &headerTransport{base: transport, headers: config.Headers}
+	}
+
+	return &retryTransport{
+		base:       transport,
+		retries:    s.retries,
+		backoff:    s.retryBackoff,
+		maxBackoff: s.retryMaxBackoff,
+		debug:      debug,
+	}, nil
+}
+
+// tlsConfig builds the TLS settings for CM from caFile, clientCert, clientKey and insecureSkipVerify
+func (c Configuration) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+
+	if c.CAFile != "" {
+		pem, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read caFile: %s", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No PEM certificates found in caFile %s", c.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if c.ClientCert != "" || c.ClientKey != "" {
+		if c.ClientCert == "" || c.ClientKey == "" {
+			return nil, errors.New("Both clientCert and clientKey are needed for a client certificate")
+		}
+		cert, err := tls.LoadX509KeyPair(c.ClientCert, c.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load client certificate: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if c.InsecureSkipVerify {
+		fmt.Fprintf(os.Stderr, "WARNING: TLS certificate verification is DISABLED for %s (insecureSkipVerify).\n", c.URL)
+		fmt.Fprintln(os.Stderr, "WARNING: The connection, including your password, can be intercepted. Use caFile instead.")
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	return tlsConfig, nil
+}
+
+// idleTimeoutTransport fails a response whose body stalls, with nothing read
+// from it for timeout, while bodies that keep arriving take as long as they need
+type idleTimeoutTransport struct {
+	base    http.RoundTripper
+	timeout time.Duration
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *idleTimeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return resp, err
+	}
+	body := &idleTimeoutBody{body: resp.Body, timeout: t.timeout, cancel: cancel}
+	body.timer = time.AfterFunc(t.timeout, func() {
+		atomic.StoreInt32(&body.stalled, 1)
+		cancel()
+	})
+	resp.Body = body
+	return resp, nil
+}
+
+// idleTimeoutBody cancels its request when nothing is read for timeout
+type idleTimeoutBody struct {
+	body    io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+	cancel  context.CancelFunc
+	stalled int32
+}
+
+// Read implements io.Reader
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	switch {
+	case err == io.EOF:
+		b.timer.Stop()
+	case err != nil && atomic.LoadInt32(&b.stalled) == 1:
+		err = fmt.Errorf("No data from CM for %s, giving up: %w", b.timeout, err)
+	case err == nil:
+		b.timer.Reset(b.timeout)
+	}
+	return n, err
+}
+
+// Close implements io.Closer
+func (b *idleTimeoutBody) Close() error {
+	b.timer.Stop()
+	err := b.body.Close()
+	b.cancel()
+	return err
+}
+
+// headerTransport adds the configured static headers to every request
+type headerTransport struct {
+	base    http.RoundTripper
+	headers map[string]string
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for k, v := range t.headers {
+		if req.Header.Get(k) == "" {
+			req.Header.Set(k, v)
+		}
+	}
+	return t.base.RoundTrip(req)
+}
+
+// dryRunTransport prints the curl command for every request that would change
+// CM and answers it with a placeholder success instead of sending it. Reads
+// and the login are sent, so that the commands carry a valid session.
+type dryRunTransport struct {
+	base http.RoundTripper
+	out  io.Writer
+}
+
+// dryRunResponse is the body of the placeholder answer to a held back request
+const dryRunResponse = `{"result":"dry run"}`
+
+// RoundTrip implements http.RoundTripper
+func (t *dryRunTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch req.Method {
+	case "GET", "HEAD", "OPTIONS":
+		return t.base.RoundTrip(req)
+	}
+	if isLoginRequest(req) {
+		return t.base.RoundTrip(req)
+	}
+
+	fmt.Fprintln(t.out, curlCommand(req, nil, false))
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/json"}},
+		Body:          ioutil.NopCloser(strings.NewReader(dryRunResponse)),
+		ContentLength: int64(len(dryRunResponse)),
+		Request:       req,
+	}, nil
+}
+
+// retryTransport retries idempotent requests that fail with a connection
+// error or a 429, 502, 503 or 504 from CM, and any request that could not
+// connect at all, with exponential backoff
+type retryTransport struct {
+	base       http.RoundTripper
+	retries    int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	debug      bool
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	attemptReq := req
+	for attempt := 0; ; attempt++ {
+		resp, err := t.base.RoundTrip(attemptReq)
+		if attempt >= t.retries || !retryable(req, resp, err) {
+			return resp, err
+		}
+		if req.Body != nil && req.GetBody == nil {
+			// the body has been consumed and can't be sent again
+			return resp, err
+		}
+
+		wait := t.wait(attempt, resp)
+		if t.debug {
+			reason := fmt.Sprint(err)
+			if resp != nil {
+				reason = resp.Status
+			}
+			log.Printf("Retrying %s %s in %s (retry %d of %d): %s", req.Method, req.URL, wait, attempt+1, t.retries, reason)
+		}
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+
+		attemptReq = req.Clone(req.Context())
+		if req.GetBody != nil {
+			if attemptReq.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// wait is the backoff before retry attempt+1, honoring a Retry-After in seconds
+func (t *retryTransport) wait(attempt int, resp *http.Response) time.Duration {
+	wait := t.backoff << uint(attempt)
+	if resp != nil {
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+			wait = time.Duration(secs) * time.Second
+		}
+	}
+	if wait > t.maxBackoff || wait <= 0 {
+		wait = t.maxBackoff
+	}
+	return wait
+}
+
+// retryable decides whether a request should be attempted again
+func retryable(req *http.Request, resp *http.Response, err error) bool {
+	if err != nil {
+		if req.Context().Err() != nil {
+			return false
+		}
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			// never reached CM, so safe for any method
+			return true
+		}
+		return idempotent(req.Method)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return idempotent(req.Method)
+	}
+	return false
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
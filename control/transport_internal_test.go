@@ -0,0 +1,181 @@
+package control
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryWait(t *testing.T) {
+	rt := &retryTransport{backoff: 100 * time.Millisecond, maxBackoff: 2 * time.Second}
+	tests := []struct {
+		attempt    int
+		retryAfter string
+		want       time.Duration
+	}{
+		{0, "", 100 * time.Millisecond},
+		{1, "", 200 * time.Millisecond},
+		{3, "", 800 * time.Millisecond},
+		{5, "", 2 * time.Second},
+		{62, "", 2 * time.Second},
+		{0, "1", time.Second},
+		{0, "120", 2 * time.Second},
+		{0, "0", 100 * time.Millisecond},
+		{0, "Wed, 21 Oct 2026 07:28:00 GMT", 100 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{Header: http.Header{}}
+		if tt.retryAfter != "" {
+			resp.Header.Set("Retry-After", tt.retryAfter)
+		}
+		if got := rt.wait(tt.attempt, resp); got != tt.want {
+			t.Errorf("wait(%d, Retry-After %q) = %s, want %s", tt.attempt, tt.retryAfter, got, tt.want)
+		}
+	}
+}
+
+// TestTimeoutStalledBody is a download that stops arriving part way, which
+// fails after the timeout, and one that arrives slowly but steadily, which
+// takes as long as it needs
+func TestTimeoutStalledBody(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 8; i++ {
+			w.Write([]byte("chunk "))
+			w.(http.Flusher).Flush()
+			if r.URL.Path == "/stalled" && i == 1 {
+				select {
+				case <-r.Context().Done():
+				case <-time.After(10 * time.Second):
+				}
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}))
+	defer s.Close()
+	transport, err := newTransport(Configuration{Timeout: "200ms", Retries: -1}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: transport}
+
+	resp, err := client.Get(s.URL + "/steady")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || strings.Count(string(b), "chunk") != 8 {
+		t.Errorf("steady body = %q, %v", b, err)
+	}
+
+	start := time.Now()
+	resp, err = client.Get(s.URL + "/stalled")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err == nil || !strings.Contains(err.Error(), "No data from CM for 200ms") {
+		t.Errorf("stalled body = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("stalled body took %s to fail", elapsed)
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	var got http.Header
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header
+	}))
+	defer s.Close()
+	transport, err := newTransport(Configuration{Headers: map[string]string{"X-Team": "portal", "Accept": "text/plain"}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest("GET", s.URL, nil)
+	req.Header.Set("Accept", "application/json")
+	resp, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got.Get("X-Team") != "portal" || got.Get("Accept") != "application/json" {
+		t.Errorf("headers sent = %v, want X-Team added and Accept kept", got)
+	}
+	if req.Header.Get("X-Team") != "" {
+		t.Error("the caller's request was changed")
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer s.Close()
+	dir := t.TempDir()
+	ca := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	notPEM := filepath.Join(dir, "ca.txt")
+	if err := ioutil.WriteFile(notPEM, []byte("not a certificate"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		config Configuration
+		err    string
+	}{
+		{Configuration{CAFile: filepath.Join(dir, "missing.pem")}, "Unable to read caFile"},
+		{Configuration{CAFile: notPEM}, "No PEM certificates found in caFile"},
+		{Configuration{ClientCert: ca}, "Both clientCert and clientKey are needed"},
+		{Configuration{ClientCert: ca, ClientKey: notPEM}, "Unable to load client certificate"},
+		{Configuration{ProxyURL: "://proxy"}, "Invalid proxyURL"},
+	}
+	for _, tt := range tests {
+		if _, err := newTransport(tt.config, false); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("newTransport(%+v) = %v, want an error with %q", tt.config, err, tt.err)
+		}
+	}
+
+	// the test server's certificate is trusted only with the CA file
+	for _, caFile := range []string{"", ca} {
+		transport, err := newTransport(Configuration{CAFile: caFile, Retries: -1}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := (&http.Client{Transport: transport}).Get(s.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if (err == nil) != (caFile != "") {
+			t.Errorf("GET with caFile %q = %v", caFile, err)
+		}
+	}
+}
+
+func TestProxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+	}))
+	defer proxy.Close()
+	transport, err := newTransport(Configuration{ProxyURL: proxy.URL, Retries: -1}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := (&http.Client{Transport: transport}).Get("http://cm.example.com/api/apis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if proxied != "http://cm.example.com/api/apis" {
+		t.Errorf("proxy got %q", proxied)
+	}
+}
@@ -0,0 +1,123 @@
+package control_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ghchinoy/atmotool/cm"
+	"github.com/ghchinoy/atmotool/cmtest"
+	"github.com/ghchinoy/atmotool/control"
+)
+
+// retryingClient logs in to s with retries that back off for at most 50ms
+func retryingClient(t *testing.T, s *cmtest.Server) *control.Client {
+	t.Helper()
+	config := s.Config()
+	config.Retries, config.RetryBackoff, config.RetryMaxBackoff = 3, "1ms", "50ms"
+	client, err := control.NewClient(config, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// count returns how many of the fake CM's requests were request, ex. GET /content
+func count(s *cmtest.Server, request string) int {
+	var n int
+	for _, r := range s.Requests() {
+		if r == request {
+			n++
+		}
+	}
+	return n
+}
+
+func TestRetry(t *testing.T) {
+	s, _ := newClient(t)
+	client := retryingClient(t, s)
+	s.PutFile("/content/home/landing/index.htm", []byte("<h1>hi</h1>"))
+
+	for _, status := range []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout} {
+		before := count(s, "GET /content/home/landing")
+		s.FailPathTimes("/content/home/landing", status, 2, "")
+		var listing cm.ApisResponse
+		if err := client.Get("/content/home/landing", &listing); err != nil {
+			t.Errorf("GET after two %ds: %v", status, err)
+		}
+		if n := count(s, "GET /content/home/landing") - before; n != 3 {
+			t.Errorf("GET after two %ds sent %d times, want 3", status, n)
+		}
+	}
+
+	// idempotent DELETEs are retried too
+	s.FailPathTimes("/content/home/landing/index.htm", http.StatusServiceUnavailable, 1, "")
+	if err := client.Delete("/content/home/landing/index.htm", nil); err != nil {
+		t.Errorf("DELETE after a 503: %v", err)
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	s, _ := newClient(t)
+	client := retryingClient(t, s)
+	s.PutFile("/content/home/landing/index.htm", []byte("<h1>hi</h1>"))
+
+	s.FailPath("/content/home/landing", http.StatusServiceUnavailable)
+	err := client.Get("/content/home/landing", nil)
+	var fault *cm.FaultError
+	if !errors.As(err, &fault) || fault.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("GET of an unavailable path = %v, want a 503 fault", err)
+	}
+	if n := count(s, "GET /content/home/landing"); n != 4 {
+		t.Errorf("GET sent %d times, want once and 3 retries", n)
+	}
+
+	// a 500 isn't a CM that's busy, so isn't retried
+	s.FailPath("/content/home/landing/index.htm", http.StatusInternalServerError)
+	client.Get("/content/home/landing/index.htm", nil)
+	if n := count(s, "GET /content/home/landing/index.htm"); n != 1 {
+		t.Errorf("GET after a 500 sent %d times, want 1", n)
+	}
+}
+
+// TestRetryAfterCapped is a Retry-After of two minutes, which waits for the
+// configured maximum backoff instead
+func TestRetryAfterCapped(t *testing.T) {
+	s, _ := newClient(t)
+	client := retryingClient(t, s)
+	s.PutFile("/content/home/landing/index.htm", []byte("<h1>hi</h1>"))
+
+	s.FailPathTimes("/content/home/landing", http.StatusTooManyRequests, 1, "120")
+	start := time.Now()
+	if err := client.Get("/content/home/landing", nil); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Retry-After 120 waited %s, want the 50ms maximum backoff", elapsed)
+	}
+}
+
+func TestNoRetryOfPOST(t *testing.T) {
+	s, _ := newClient(t)
+	client := retryingClient(t, s)
+	p := filepath.Join(t.TempDir(), "custom.less")
+	if err := ioutil.WriteFile(p, []byte("a {}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s.FailPathTimes("/resources/theme/default/less", http.StatusServiceUnavailable, 1, "")
+	err := client.Upload("/resources/theme/default/less?unpack=false", "File", p, nil)
+	var fault *cm.FaultError
+	if !errors.As(err, &fault) || fault.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("upload to a busy CM = %v, want a 503 fault", err)
+	}
+	if n := count(s, "POST /resources/theme/default/less?unpack=false"); n != 1 {
+		t.Errorf("POST sent %d times, want 1", n)
+	}
+	if _, ok := s.File("/resources/theme/default/less/custom.less"); ok {
+		t.Error("POST was retried")
+	}
+}
@@ -0,0 +1,195 @@
+package control
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// progressInterval is how often upload progress is redrawn, and how long an
+// upload runs before it's first shown, so small uploads don't flash by
+const progressInterval = 200 * time.Millisecond
+
+// newFileUploadRequest returns a multipart POST request for uri with the file
+// at path as the form field paramName. The body is written through a pipe
+// as it's read, with a Content-Length worked out from the file's size and
+// the multipart framing, and GetBody opens the file again for a retry. A
+// non-nil progress is updated as the body is read.
+func newFileUploadRequest(uri string, paramName string, path string, progress *uploadProgress) (*http.Request, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("%s is a directory", path)
+	}
+
+	// the framing of an empty file, with the boundary the body will use
+	var framing bytes.Buffer
+	fw := multipart.NewWriter(&framing)
+	if _, err = fw.CreateFormFile(paramName, filepath.Base(path)); err != nil {
+		return nil, err
+	}
+	if err = fw.Close(); err != nil {
+		return nil, err
+	}
+	length := int64(framing.Len()) + info.Size()
+
+	body := func() (io.ReadCloser, error) {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		pr, pw := io.Pipe()
+		go func() {
+			defer file.Close()
+			mw := multipart.NewWriter(pw)
+			err := mw.SetBoundary(fw.Boundary())
+			if err == nil {
+				var part io.Writer
+				if part, err = mw.CreateFormFile(paramName, filepath.Base(path)); err == nil {
+					_, err = io.Copy(part, file)
+				}
+			}
+			if err == nil {
+				err = mw.Close()
+			}
+			pw.CloseWithError(err)
+		}()
+		if progress != nil {
+			return progress.reader(pr, length), nil
+		}
+		return pr, nil
+	}
+	first, err := body()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", uri, first)
+	if err != nil {
+		first.Close()
+		return nil, err
+	}
+	req.ContentLength = length
+	req.GetBody = body
+	req.Header.Add("Content-Type", fw.FormDataContentType())
+
+	// remembered for the curl command of a dry run, in a variable of its own
+	// as the body's goroutine reads path
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+	req = req.WithContext(context.WithValue(req.Context(), uploadKey{}, formFile{field: paramName, path: abs}))
+
+	return req, nil
+}
+
+// showsProgress reports whether uploads show their progress on stderr; not
+// when quiet, debugging or in a dry run, or when stdout isn't a terminal
+func (c *Client) showsProgress() bool {
+	return !c.Config.Quiet && !c.debug && !c.Config.DryRun && term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// uploadProgress shows how much of an upload has been sent, its rate and
+// how long it has to go
+type uploadProgress struct {
+	// mu guards against the body still being read, by the transport, when
+	// CM has answered before reading all of it
+	mu    sync.Mutex
+	name  string
+	total int64
+	done  int64
+	start time.Time
+	drawn time.Time
+	shown bool
+}
+
+// reader counts what's read of an upload's body, of total bytes, starting
+// the count again for a retry
+func (p *uploadProgress) reader(r io.ReadCloser, total int64) io.ReadCloser {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.total, p.done, p.start = total, 0, time.Now()
+	return &progressReader{ReadCloser: r, progress: p}
+}
+
+type progressReader struct {
+	io.ReadCloser
+	progress *uploadProgress
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	r.progress.add(int64(n))
+	return n, err
+}
+
+func (p *uploadProgress) add(n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done += n
+	now := time.Now()
+	if n > 0 && p.done >= p.total && p.shown {
+		// the last of the body, drawn now for the rate of sending it
+		p.draw(now)
+		return
+	}
+	if now.Sub(p.drawn) < progressInterval || (!p.shown && now.Sub(p.start) < progressInterval) {
+		return
+	}
+	p.draw(now)
+}
+
+func (p *uploadProgress) draw(now time.Time) {
+	p.drawn, p.shown = now, true
+	line := fmt.Sprintf("Uploading %s  %s of %s", p.name, formatBytes(p.done), formatBytes(p.total))
+	if elapsed := now.Sub(p.start).Seconds(); elapsed > 0 && p.done > 0 {
+		rate := float64(p.done) / elapsed
+		line += fmt.Sprintf("  %s/s", formatBytes(int64(rate)))
+		if p.done < p.total {
+			eta := time.Duration(float64(p.total-p.done) / rate * float64(time.Second))
+			line += fmt.Sprintf("  ETA %s", eta.Round(time.Second))
+		}
+	}
+	// pad over the end of a longer previous line
+	fmt.Fprintf(os.Stderr, "\r%-70s", line)
+}
+
+// end finishes the progress line of an upload that showed it
+func (p *uploadProgress) end() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.shown {
+		return
+	}
+	fmt.Fprintln(os.Stderr)
+}
+
+// formatBytes formats a number of bytes for people, ex. 12.3 MB
+func formatBytes(n int64) string {
+	units := []string{"KB", "MB", "GB", "TB"}
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+	v := float64(n) / 1024
+	unit := 0
+	for v >= 1024 && unit < len(units)-1 {
+		v /= 1024
+		unit++
+	}
+	return strings.TrimSuffix(fmt.Sprintf("%.1f", v), ".0") + " " + units[unit]
+}
@@ -0,0 +1,25 @@
+package control
+
+import "testing"
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1 KB"},
+		{1536, "1.5 KB"},
+		{1234567, "1.2 MB"},
+		{10 << 20, "10 MB"},
+		{3 << 30, "3 GB"},
+		{1 << 40, "1 TB"},
+		{5 << 50, "5120 TB"},
+	}
+	for _, tt := range tests {
+		if got := formatBytes(tt.n); got != tt.want {
+			t.Errorf("formatBytes(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package control_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestUpload streams files to the CMS; run with -race, as the body is
+// written by a goroutine while the request is built and sent
+func TestUpload(t *testing.T) {
+	s, client := newClient(t)
+	dir := t.TempDir()
+	sizes := map[string]int{"empty.txt": 0, "small.css": 100, "large.bin": 3 << 20}
+	for name, size := range sizes {
+		content := make([]byte, size)
+		rand.Read(content)
+		p := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(p, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := client.Upload("/content/uploads?unpack=false", "File", p, nil); err != nil {
+			t.Fatalf("Upload %s: %v", name, err)
+		}
+		got, ok := s.File("/content/uploads/" + name)
+		if !ok || !bytes.Equal(got, content) {
+			t.Errorf("%s in the CMS is %d bytes, %v, want %d bytes uploaded", name, len(got), ok, size)
+		}
+	}
+}
+
+func TestUploadRelativePath(t *testing.T) {
+	s, client := newClient(t)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if err = ioutil.WriteFile("custom.less", []byte("a {}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err = client.Upload("/resources/theme/default/less?unpack=false", "File", "custom.less", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, _ := s.File("/resources/theme/default/less/custom.less"); string(got) != "a {}" {
+		t.Errorf("custom.less = %q", got)
+	}
+}
+
+func TestUploadMissingFile(t *testing.T) {
+	_, client := newClient(t)
+	err := client.Upload("/content/uploads", "File", filepath.Join(t.TempDir(), "missing"), nil)
+	if !os.IsNotExist(err) {
+		t.Errorf("Upload of a missing file = %v, want not exist", err)
+	}
+}
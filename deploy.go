@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/ghchinoy/atmotool/control"
+	"github.com/ghchinoy/atmotool/output"
+	"github.com/ghchinoy/atmotool/zip"
+	"github.com/ryanuber/columnize"
+	"gopkg.in/yaml.v2"
+)
+
+// deployManifestName is the deploy manifest looked for in a theme project
+const deployManifestName = "atmotool.yaml"
+
+// deployManifest says how a theme project is uploaded to the CMS
+type deployManifest struct {
+	// Theme is rebuilt at the end, and is the theme of steps without one;
+	// it defaults to theme in the configuration, then default
+	Theme string       `yaml:"theme"`
+	Steps []deployStep `yaml:"steps"`
+	// CustomLess is uploaded to the theme's less folder after the steps,
+	// defaulting to custom.less next to the manifest if there is one
+	CustomLess string `yaml:"customLess"`
+}
+
+// deployStep uploads a local folder or file to a CMS folder
+type deployStep struct {
+	// Local is relative to the manifest
+	Local string `yaml:"local"`
+	// Path is a CMS folder, or with Theme, a folder relative to the theme's
+	// /resources/theme/<theme>, which is the default
+	Path  string `yaml:"path"`
+	Theme string `yaml:"theme"`
+	// Unpack unpacks the zip of a folder, or a zip file, into Path. It's the
+	// default for both; other files are never unpacked.
+	Unpack *bool `yaml:"unpack"`
+}
+
+// deployResult is the outcome of a step of a deploy
+type deployResult struct {
+	Step   string `json:"step"`
+	Local  string `json:"local"`
+	Path   string `json:"path"`
+	Status string `json:"status"`
+}
+
+// loadDeployManifest reads a deploy manifest, from a file or a directory
+// holding atmotool.yaml, returning it and the directory its paths are
+// relative to
+func loadDeployManifest(location string) (deployManifest, string, error) {
+	var manifest deployManifest
+	if info, err := os.Stat(location); err == nil && info.IsDir() {
+		location = filepath.Join(location, deployManifestName)
+	}
+	b, err := ioutil.ReadFile(location)
+	if err != nil {
+		return manifest, "", err
+	}
+	if err = yaml.UnmarshalStrict(b, &manifest); err != nil {
+		return manifest, "", fmt.Errorf("Unable to read deploy manifest %s: %s", location, err)
+	}
+	if len(manifest.Steps) == 0 {
+		return manifest, "", fmt.Errorf("Deploy manifest %s has no steps", location)
+	}
+	for i, s := range manifest.Steps {
+		if s.Local == "" {
+			return manifest, "", fmt.Errorf("Step %d of deploy manifest %s has no local folder or file", i+1, location)
+		}
+		if s.Theme != "" && strings.HasPrefix(s.Path, "/") {
+			return manifest, "", fmt.Errorf("Step %d of deploy manifest %s has a theme, so its path must be relative to the theme", i+1, location)
+		}
+	}
+	if manifest.Theme == "" {
+		manifest.Theme = config.Theme
+	}
+	if manifest.Theme == "" {
+		manifest.Theme = "default"
+	}
+	dir := filepath.Dir(location)
+	if manifest.CustomLess == "" {
+		if _, err := os.Stat(filepath.Join(dir, "custom.less")); err == nil {
+			manifest.CustomLess = "custom.less"
+		}
+	}
+	return manifest, dir, nil
+}
+
+// cmsPath returns the CMS folder a step uploads to
+func (s deployStep) cmsPath(theme string) string {
+	if strings.HasPrefix(s.Path, "/") {
+		return cleanCMSPath(s.Path)
+	}
+	if s.Theme != "" {
+		theme = s.Theme
+	}
+	return cleanCMSPath(path.Join("/resources/theme", theme, s.Path))
+}
+
+// deploy uploads a theme project as its deploy manifest says, in order,
+// then its custom.less, and then rebuilds the styles of the themes deployed
+// to, once each. It stops at the first failure, and outputs the status of
+// every step.
+func deploy(location string) error {
+	manifest, dir, err := loadDeployManifest(location)
+	if err != nil {
+		return err
+	}
+	client, err := connect()
+	if err != nil {
+		return err
+	}
+	staging, cleanup, err := stagingDir()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	done := "uploaded"
+	if config.DryRun {
+		done = "dry run"
+	}
+	var results []deployResult
+	var failed error
+	themes := []string{manifest.Theme}
+	for i, s := range manifest.Steps {
+		cmsPath := s.cmsPath(manifest.Theme)
+		r := deployResult{Step: fmt.Sprint(i + 1), Local: s.Local, Path: cmsPath, Status: "skipped"}
+		if s.Theme != "" && !containsString(themes, s.Theme) {
+			themes = append(themes, s.Theme)
+		}
+		if failed == nil {
+			log.Printf("Uploading %s to %s", s.Local, cmsPath)
+			if err := deployStepUpload(client, s, filepath.Join(dir, s.Local), cmsPath, staging, i+1); err != nil {
+				failed = fmt.Errorf("Step %d, uploading %s to %s: %w", i+1, s.Local, cmsPath, err)
+				r.Status = "failed: " + err.Error()
+			} else {
+				r.Status = done
+			}
+		}
+		results = append(results, r)
+	}
+
+	if manifest.CustomLess != "" {
+		lessPath := "/resources/theme/" + manifest.Theme + "/less"
+		r := deployResult{Step: "less", Local: manifest.CustomLess, Path: lessPath, Status: "skipped"}
+		if failed == nil {
+			log.Printf("Uploading %s to %s", manifest.CustomLess, lessPath)
+			if err := uploadAs(client, filepath.Join(dir, manifest.CustomLess), lessPath, "custom.less"); err != nil {
+				failed = fmt.Errorf("Uploading %s: %w", manifest.CustomLess, err)
+				r.Status = "failed: " + err.Error()
+			} else {
+				r.Status = done
+			}
+		}
+		results = append(results, r)
+	}
+
+	for _, theme := range themes {
+		r := deployResult{Step: "rebuild", Path: "/resources/theme/" + theme, Status: "skipped"}
+		if failed == nil {
+			if err := rebuildStyles(config, theme); err != nil {
+				failed = err
+				r.Status = "failed: " + err.Error()
+			} else {
+				r.Status = "rebuilt"
+			}
+		}
+		results = append(results, r)
+	}
+
+	err = output.Render(results, format, func() {
+		data := []string{"Step | Local | CMS Path | Status"}
+		for _, r := range results {
+			data = append(data, fmt.Sprintf("%s | %s | %s | %s", r.Step, r.Local, r.Path, r.Status))
+		}
+		fmt.Println(columnize.SimpleFormat(data))
+	})
+	if failed != nil {
+		return failed
+	}
+	return err
+}
+
+// deployStepUpload uploads a step's local folder, zipped, or file to a CMS folder
+func deployStepUpload(client *control.Client, s deployStep, local string, cmsPath string, staging string, n int) error {
+	info, err := os.Stat(local)
+	if err != nil {
+		return err
+	}
+	unpack := info.IsDir() || strings.HasSuffix(local, ".zip")
+	if s.Unpack != nil {
+		unpack = unpack && *s.Unpack
+	}
+	query := "?unpack=false"
+	if unpack {
+		query = "?unpack=true"
+	}
+	if !info.IsDir() {
+		return uploadFile(client, local, cmsURI(cmsPath)+query)
+	}
+
+	// each step's zip is named after its folder, which is what a folder that
+	// isn't unpacked is called in the CMS
+	archive := filepath.Join(staging, fmt.Sprint(n), filepath.Base(local)+".zip")
+	if err = os.MkdirAll(filepath.Dir(archive), 0755); err != nil {
+		return err
+	}
+	if err = zip.ZipFolder(filepath.Clean(local), archive); err != nil {
+		return err
+	}
+	return uploadFile(client, archive, cmsURI(cmsPath)+query)
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
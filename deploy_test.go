@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ghchinoy/atmotool/output"
+)
+
+func TestLoadDeployManifest(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		theme    string
+		less     string
+		err      string
+	}{
+		{"theme", "theme: blue\nsteps:\n  - local: resources\n", "blue", "", ""},
+		{"default theme", "steps:\n  - local: resources\n", "default", "", ""},
+		{"custom.less", "steps:\n  - local: resources\n", "default", "custom.less", ""},
+		{"no steps", "theme: blue\n", "", "", "has no steps"},
+		{"no local", "steps:\n  - path: /content/home\n", "", "", "Step 1 of deploy manifest"},
+		{"absolute path in a theme", "steps:\n  - local: a\n  - local: less\n    theme: partner\n    path: /resources/theme/partner/less\n", "", "", "Step 2 of deploy manifest"},
+		{"unknown field", "steps:\n  - local: a\n    unzip: false\n", "", "", "Unable to read deploy manifest"},
+		{"not yaml", "steps: [", "", "", "Unable to read deploy manifest"},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		files := map[string]string{deployManifestName: tt.manifest}
+		if tt.less != "" {
+			files[tt.less] = "a {}"
+		}
+		writeFiles(t, dir, files)
+
+		// a directory holding the manifest, and the manifest itself
+		for _, location := range []string{dir, filepath.Join(dir, deployManifestName)} {
+			manifest, manifestDir, err := loadDeployManifest(location)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("%s: %v, want an error with %q", tt.name, err, tt.err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+				continue
+			}
+			if manifest.Theme != tt.theme || manifest.CustomLess != tt.less || manifestDir != dir {
+				t.Errorf("%s: theme %q, customLess %q in %s", tt.name, manifest.Theme, manifest.CustomLess, manifestDir)
+			}
+		}
+	}
+
+	if _, _, err := loadDeployManifest(t.TempDir()); err == nil {
+		t.Error("loading a directory without a manifest succeeded")
+	}
+}
+
+// deployProject writes a theme project with a manifest of steps
+func deployProject(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		deployManifestName: `theme: default
+steps:
+  - local: landing
+    path: /content/home/landing
+  - local: resources
+  - local: partner-less
+    theme: partner
+    path: less
+  - local: downloads
+    path: /content/downloads
+    unpack: false
+`,
+		"landing/index.htm":         "<h1>hi</h1>",
+		"resources/i18n/en.json":    `{"title": "Portal"}`,
+		"partner-less/partner.less": "b {}",
+		"downloads/guide.pdf":       "%PDF",
+		"custom.less":               "a { color: red; }",
+		"resources/.DS_Store":       "junk",
+	})
+	return dir
+}
+
+func TestDeploy(t *testing.T) {
+	s := newTestCM(t)
+	dir := deployProject(t)
+
+	if err := deploy(dir); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"POST /content/home/landing?unpack=true",
+		"POST /resources/theme/default?unpack=true",
+		"POST /resources/theme/partner/less?unpack=true",
+		"POST /content/downloads?unpack=false",
+		"POST /resources/theme/default/less?unpack=false",
+		"POST /resources/branding/generatestyles",
+		"POST /resources/branding/generatestyles",
+	}
+	if got := posts(s.Requests()); !reflect.DeepEqual(got, want) {
+		t.Errorf("deploy requests =\n%v\nwant\n%v", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if got := s.Rebuilds(); !reflect.DeepEqual(got, []string{"default", "partner"}) {
+		t.Errorf("rebuilds = %v", got)
+	}
+	for _, p := range []string{
+		"/content/home/landing/index.htm",
+		"/resources/theme/default/i18n/en.json",
+		"/resources/theme/partner/less/partner.less",
+		"/content/downloads/downloads.zip",
+		"/resources/theme/default/less/custom.less",
+	} {
+		if _, ok := s.File(p); !ok {
+			t.Errorf("%s wasn't deployed", p)
+		}
+	}
+	if _, ok := s.File("/resources/theme/default/.DS_Store"); ok {
+		t.Error(".DS_Store was deployed")
+	}
+}
+
+// TestDeployStopsAtFailure skips the steps after a failed one, and the
+// rebuilds, reporting a status for each
+func TestDeployStopsAtFailure(t *testing.T) {
+	s := newTestCM(t)
+	dir := deployProject(t)
+	s.FailPath("/resources/theme/default", 500)
+
+	var out bytes.Buffer
+	format, _ = output.NewOptions("json", "")
+	format.Out = &out
+	err := deploy(filepath.Join(dir, deployManifestName))
+	if err == nil || !strings.Contains(err.Error(), "Step 2, uploading resources to /resources/theme/default") {
+		t.Errorf("deploy = %v, want step 2 to fail", err)
+	}
+	if got := posts(s.Requests()); len(got) != 2 || got[1] != "POST /resources/theme/default?unpack=true" {
+		t.Errorf("deploy carried on after the failure: %v", got)
+	}
+
+	var results []deployResult
+	if err := json.Unmarshal(out.Bytes(), &results); err != nil {
+		t.Fatalf("%v: %s", err, out.String())
+	}
+	var statuses []string
+	for _, r := range results {
+		status := r.Status
+		if strings.HasPrefix(status, "failed: ") {
+			status = "failed"
+		}
+		statuses = append(statuses, r.Step+" "+status)
+	}
+	want := []string{"1 uploaded", "2 failed", "3 skipped", "4 skipped", "less skipped", "rebuild skipped", "rebuild skipped"}
+	if !reflect.DeepEqual(statuses, want) {
+		t.Errorf("statuses = %v, want %v", statuses, want)
+	}
+}
+
+// TestDeployMissingLocal fails the step of a local folder that isn't there
+func TestDeployMissingLocal(t *testing.T) {
+	s := newTestCM(t)
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{deployManifestName: "steps:\n  - local: missing\n"})
+	if err := deploy(dir); err == nil || !strings.Contains(err.Error(), "Step 1, uploading missing") {
+		t.Errorf("deploy of a missing folder = %v", err)
+	}
+	if n := uploads(s); n != 0 {
+		t.Errorf("deploy made %d uploads", n)
+	}
+}
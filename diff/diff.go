@@ -0,0 +1,180 @@
+// Package diff produces unified diffs of text, ex. to compare a local theme
+// file with its copy in the CMS.
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// context is the number of unchanged lines shown around a change
+	context = 3
+	// maxCells bounds the memory used to find the longest common
+	// subsequence; larger files are diffed as one replaced block
+	maxCells = 4000000
+)
+
+// op is a line of a diff: ' ' unchanged, '-' removed or '+' added
+type op struct {
+	kind byte
+	text string
+}
+
+// Unified returns the unified diff turning text a, named aName, into text b,
+// named bName. It's empty if they have the same lines.
+func Unified(aName string, bName string, a string, b string) string {
+	ops := edits(lines(a), lines(b))
+	changed := false
+	for _, o := range ops {
+		if o.kind != ' ' {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return ""
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", aName, bName)
+
+	// aLine and bLine are the lines of a and b before each op
+	aLine := make([]int, len(ops)+1)
+	bLine := make([]int, len(ops)+1)
+	for k, o := range ops {
+		aLine[k+1], bLine[k+1] = aLine[k], bLine[k]
+		if o.kind != '+' {
+			aLine[k+1]++
+		}
+		if o.kind != '-' {
+			bLine[k+1]++
+		}
+	}
+
+	for i := 0; i < len(ops); {
+		for i < len(ops) && ops[i].kind == ' ' {
+			i++
+		}
+		if i == len(ops) {
+			break
+		}
+		// a hunk runs from context lines before its first change to context
+		// lines after its last, taking in changes that are close together
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for {
+			k := end + 1
+			for k < len(ops) && ops[k].kind == ' ' {
+				k++
+			}
+			if k == len(ops) || k-end-1 > 2*context {
+				break
+			}
+			end = k
+		}
+		stop := end + context + 1
+		if stop > len(ops) {
+			stop = len(ops)
+		}
+
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n",
+			hunkRange(aLine[start], aLine[stop]-aLine[start]),
+			hunkRange(bLine[start], bLine[stop]-bLine[start]))
+		for _, o := range ops[start:stop] {
+			fmt.Fprintf(&out, "%c%s\n", o.kind, o.text)
+		}
+		i = stop
+	}
+	return out.String()
+}
+
+// hunkRange formats the start and length of a hunk, numbering lines from 1,
+// or from 0 for an empty range
+func hunkRange(before int, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", before)
+	}
+	return fmt.Sprintf("%d,%d", before+1, count)
+}
+
+func lines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	l := strings.Split(strings.Replace(s, "\r\n", "\n", -1), "\n")
+	if l[len(l)-1] == "" {
+		l = l[:len(l)-1]
+	}
+	return l
+}
+
+// edits returns the ops turning a into b, keeping the longest common
+// subsequence of lines
+func edits(a []string, b []string) []op {
+	var head, tail []op
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		head = append(head, op{' ', a[0]})
+		a, b = a[1:], b[1:]
+	}
+	for len(a) > 0 && len(b) > 0 && a[len(a)-1] == b[len(b)-1] {
+		tail = append([]op{{' ', a[len(a)-1]}}, tail...)
+		a, b = a[:len(a)-1], b[:len(b)-1]
+	}
+
+	n, m := len(a), len(b)
+	var middle []op
+	if (n+1)*(m+1) > maxCells {
+		for _, l := range a {
+			middle = append(middle, op{'-', l})
+		}
+		for _, l := range b {
+			middle = append(middle, op{'+', l})
+		}
+		return append(append(head, middle...), tail...)
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case a[i] == b[j]:
+			middle = append(middle, op{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			middle = append(middle, op{'-', a[i]})
+			i++
+		default:
+			middle = append(middle, op{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		middle = append(middle, op{'-', a[i]})
+	}
+	for ; j < m; j++ {
+		middle = append(middle, op{'+', b[j]})
+	}
+	return append(append(head, middle...), tail...)
+}
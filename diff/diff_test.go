@@ -0,0 +1,87 @@
+package diff
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestUnified(t *testing.T) {
+	numbers := func(from int, to int, replace map[int]string) string {
+		var l []string
+		for i := from; i <= to; i++ {
+			if r, ok := replace[i]; ok {
+				l = append(l, r)
+			} else {
+				l = append(l, strconv.Itoa(i))
+			}
+		}
+		return strings.Join(l, "\n") + "\n"
+	}
+
+	tests := []struct {
+		name string
+		a    string
+		b    string
+		want string
+	}{
+		{"same", "a\nb\n", "a\nb\n", ""},
+		{"line endings", "a\r\nb\r\n", "a\nb", ""},
+		{"empty", "", "", ""},
+		{"changed line", "a\nb\nc\n", "a\nB\nc\n", "--- a\n+++ b\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n"},
+		{"added to empty", "", "x\n", "--- a\n+++ b\n@@ -0,0 +1,1 @@\n+x\n"},
+		{"all removed", "x\ny\n", "", "--- a\n+++ b\n@@ -1,2 +0,0 @@\n-x\n-y\n"},
+		{"appended", "a\nb\n", "a\nb\nc\n", "--- a\n+++ b\n@@ -1,2 +1,3 @@\n a\n b\n+c\n"},
+		{"two hunks", numbers(1, 12, nil), numbers(1, 12, map[int]string{1: "X", 12: "Y"}),
+			"--- a\n+++ b\n" +
+				"@@ -1,4 +1,4 @@\n-1\n+X\n 2\n 3\n 4\n" +
+				"@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+Y\n"},
+		{"one hunk for close changes", numbers(1, 10, nil), numbers(1, 10, map[int]string{2: "X", 8: "Y"}),
+			"--- a\n+++ b\n" +
+				"@@ -1,10 +1,10 @@\n 1\n-2\n+X\n 3\n 4\n 5\n 6\n 7\n-8\n+Y\n 9\n 10\n"},
+	}
+	for _, tt := range tests {
+		if got := Unified("a", "b", tt.a, tt.b); got != tt.want {
+			t.Errorf("%s: Unified =\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEdits(t *testing.T) {
+	tests := []struct {
+		a    string
+		b    string
+		want string
+	}{
+		{"a b c", "a c d", " a -b  c +d"},
+		{"a b", "a b", " a  b"},
+		{"", "x y", "+x +y"},
+		{"x y", "", "-x -y"},
+		{"a b c d", "b d", "-a  b -c  d"},
+		{"a b", "b a", "-a  b +a"},
+	}
+	for _, tt := range tests {
+		var ops []string
+		for _, o := range edits(strings.Fields(tt.a), strings.Fields(tt.b)) {
+			ops = append(ops, string(o.kind)+o.text)
+		}
+		if got := strings.Join(ops, " "); got != tt.want {
+			t.Errorf("edits(%q, %q) = %q, want %q", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestEditsLarge(t *testing.T) {
+	// beyond maxCells, the middle is replaced as a block
+	var a, b []string
+	for i := 0; i < 2100; i++ {
+		a = append(a, "a"+strconv.Itoa(i))
+		b = append(b, "b"+strconv.Itoa(i))
+	}
+	a = append([]string{"head"}, append(a, "tail")...)
+	b = append([]string{"head"}, append(b, "tail")...)
+	ops := edits(a, b)
+	if len(ops) != 2+2100*2 || ops[0].kind != ' ' || ops[1].kind != '-' || ops[2101].kind != '+' || ops[len(ops)-1].kind != ' ' {
+		t.Errorf("edits of large files = %d ops", len(ops))
+	}
+}
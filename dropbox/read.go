@@ -2,20 +2,12 @@ package dropbox
 
 import (
 	"bytes"
-	"io"
-	"io/ioutil"
 	"log"
-	"mime/multipart"
-	"net/http"
-	"os"
-	"path/filepath"
 
 	"golang.org/x/net/html"
 
 	"encoding/json"
 
-	"strings"
-
 	"github.com/ghchinoy/atmotool/control"
 )
 
@@ -54,73 +46,32 @@ func AddSpecToDropbox(config control.Configuration, specfilepath string, debug b
 	}
 
 	// log in
-	client, _, err := control.LoginToCM(config, debug)
+	client, err := control.NewClient(config, debug)
 	if err != nil {
-		log.Fatalln(err)
 		return specresponse, err
 	}
 
-	// set url
-	url := config.URL + DropboxReadFileDetailsURI
-	extraParams := map[string]string{
-		"none": "really",
-	}
-
-	// create a request
-	req, err := constructUploadRequestForFile(url, extraParams, "FileName", specfilepath)
-	req.Header.Add("Accept", "application/json, application/vnd.soa.v81+json")
-	control.AddCsrfHeader(req, client)
-
-	if debug {
-		log.Println("POST to", url)
-		log.Println("* URL", url)
-		control.DebugRequestHeader(req)
-		log.Println("curl\n", control.CURLThis(client, req))
-	}
-
-	// do the request
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Fatalln(err)
+	// the response may be JSON or JSON wrapped in HTML
+	var b []byte
+	if err = client.Upload(DropboxReadFileDetailsURI, "FileName", specfilepath, &b); err != nil {
 		return specresponse, err
 	}
 
-	// read response body
-	// why's this bit here, since ioutil.ReadAll is used?
-	body := &bytes.Buffer{}
-	_, err = body.ReadFrom(resp.Body)
-	if err != nil {
-		log.Fatalln(err)
-	}
-	resp.Body.Close()
-
-	b, _ := ioutil.ReadAll(body)
-
-	// if non 200, print code
-	if resp.StatusCode != 200 {
-		log.Println(resp.Status)
-	}
-	// if debug, show headers and contents
-	if debug {
-		log.Println("Response")
-		control.DebugResponseHeader(resp)
-		log.Printf("%s", b)
-	}
-
-	specresponse, err = dealWithResponse(resp.Header.Get("Content-Type"), b)
+	specresponse, err = dealWithResponse(b)
 	if err != nil {
 		log.Println("Can't convert response.", err.Error())
-		return specresponse, nil
+		return specresponse, err
 	}
 
 	return specresponse, nil
 }
 
-// parses the HTML response from adding a spec doc to the platform dropbox
-func dealWithResponse(contenttype string, body []byte) (ReadFileDetailsResponse, error) {
+// parses the response from adding a spec doc to the platform dropbox, JSON
+// or JSON wrapped in HTML
+func dealWithResponse(body []byte) (ReadFileDetailsResponse, error) {
 	var rfd ReadFileDetailsResponse
 
-	if strings.Contains(contenttype, "application/json") {
+	if json.Valid(bytes.TrimSpace(body)) {
 		err := json.Unmarshal(body, &rfd)
 		if err != nil {
 			log.Println("Can't convert response.")
@@ -164,41 +115,6 @@ func dealWithResponse(contenttype string, body []byte) (ReadFileDetailsResponse,
 
 }
 
-// returns an http.request ready to use to POST a file's contents to a URI
-func constructUploadRequestForFile(uri string, params map[string]string, paramName, path string) (*http.Request, error) {
-
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
-
-	part, err := writer.CreateFormFile(paramName, filepath.Base(path))
-	if err != nil {
-		return nil, err
-	}
-
-	_, err = io.Copy(part, file)
-	if err != nil {
-		log.Fatalln(err)
-		return nil, err
-	}
-
-	err = writer.Close()
-	if err != nil {
-		return nil, err
-	}
-
-	req, _ := http.NewRequest("POST", uri, body)
-	req.Header.Add("Content-Type", writer.FormDataContentType())
-
-	return req, nil
-
-}
-
 // ReadURL provides information about a file located at an URL
 func ReadURL(config control.Configuration, debug bool) error {
 	return nil
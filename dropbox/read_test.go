@@ -0,0 +1,43 @@
+package dropbox
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/ghchinoy/atmotool/cmtest"
+)
+
+func TestAddSpecToDropbox(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	s := cmtest.NewServer()
+	defer s.Close()
+	spec := filepath.Join(t.TempDir(), "petstore.json")
+	if err := ioutil.WriteFile(spec, []byte(`{"swagger": "2.0", "info": {"title": "Petstore"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := AddSpecToDropbox(s.Config(), spec, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.FileName != "petstore.json" || r.DropboxFileID == 0 {
+		t.Errorf("response = %+v", r)
+	}
+	if len(r.ServiceDescriptorDocument) != 1 || len(r.ServiceDescriptorDocument[0].ServiceName) != 1 ||
+		r.ServiceDescriptorDocument[0].ServiceName[0] != "Petstore" {
+		t.Errorf("services = %+v", r.ServiceDescriptorDocument)
+	}
+}
+
+func TestDealWithResponse(t *testing.T) {
+	for _, body := range []string{
+		`{"FileName": "a.json", "DropboxFileId": 7}`,
+		`<html><body>{"FileName": "a.json", "DropboxFileId": 7}</body></html>`,
+	} {
+		r, err := dealWithResponse([]byte(body))
+		if err != nil || r.FileName != "a.json" || r.DropboxFileID != 7 {
+			t.Errorf("dealWithResponse(%s) = %+v, %v", body, r, err)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+// Package output renders command results as a table, JSON, YAML, CSV or a
+// Go text/template, so that commands only build the data.
+package output
+
+import (
+	"encoding"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// Table is the default, human readable output
+	Table = "table"
+	// JSON outputs indented JSON
+	JSON = "json"
+	// YAML outputs YAML with the same keys as JSON
+	YAML = "yaml"
+	// CSV outputs a header row and a row per record
+	CSV = "csv"
+	// Template executes Options.Template with the result
+	Template = "template"
+)
+
+// Options selects how results are rendered
+type Options struct {
+	Format   string
+	Template string
+	Out      io.Writer
+}
+
+// NewOptions validates a format and template, as given on the command line.
+// A template implies the template format.
+func NewOptions(format string, tmpl string) (Options, error) {
+	opts := Options{Format: strings.ToLower(format), Template: tmpl, Out: os.Stdout}
+	if opts.Format == "" {
+		opts.Format = Table
+	}
+	if tmpl != "" {
+		if opts.Format != Table && opts.Format != Template {
+			return opts, fmt.Errorf("--template can't be used with --output %s", format)
+		}
+		opts.Format = Template
+	}
+	switch opts.Format {
+	case Table, JSON, YAML, CSV:
+	case Template:
+		if tmpl == "" {
+			return opts, fmt.Errorf("--output template needs a --template")
+		}
+	default:
+		return opts, fmt.Errorf("Unknown output format %q, use table, json, yaml, csv or template", format)
+	}
+	return opts, nil
+}
+
+// Render writes v in the selected format. table prints the human readable
+// form and is only called for table output.
+func Render(v interface{}, opts Options, table func()) error {
+	w := opts.Out
+	if w == nil {
+		w = os.Stdout
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Slice && rv.IsNil() {
+		// an empty list, not null
+		v = reflect.MakeSlice(rv.Type(), 0, 0).Interface()
+	}
+	switch opts.Format {
+	case "", Table:
+		table()
+		return nil
+	case JSON:
+		b, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", b)
+		return err
+	case YAML:
+		return writeYAML(w, v)
+	case CSV:
+		return writeCSV(w, v)
+	case Template:
+		t, err := template.New("output").Funcs(template.FuncMap{"json": toJSON}).Parse(opts.Template)
+		if err != nil {
+			return fmt.Errorf("Invalid template: %s", err)
+		}
+		return t.Execute(w, v)
+	}
+	return fmt.Errorf("Unknown output format %q", opts.Format)
+}
+
+// writeYAML goes through JSON so that YAML keys match the JSON output
+func writeYAML(w io.Writer, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var data interface{}
+	if err = yaml.Unmarshal(b, &data); err != nil {
+		return err
+	}
+	b, err = yaml.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// writeCSV writes a struct, or a slice of structs, as CSV with a header of
+// the JSON field names. Nested values are written as JSON.
+func writeCSV(w io.Writer, v interface{}) error {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	var rows []reflect.Value
+	t := rv.Type()
+	if rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+		t = t.Elem()
+		for i := 0; i < rv.Len(); i++ {
+			if row := reflect.Indirect(rv.Index(i)); row.IsValid() {
+				rows = append(rows, row)
+			}
+		}
+	} else {
+		rows = append(rows, rv)
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return fmt.Errorf("CSV output needs records, not %s", t)
+	}
+
+	var fields []int
+	var header []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := f.Name
+		if tag := strings.Split(f.Tag.Get("json"), ",")[0]; tag == "-" {
+			continue
+		} else if tag != "" {
+			name = tag
+		}
+		fields = append(fields, i)
+		header = append(header, name)
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		var record []string
+		for _, i := range fields {
+			cell, err := csvCell(row.Field(i))
+			if err != nil {
+				return err
+			}
+			record = append(record, cell)
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func csvCell(v reflect.Value) (string, error) {
+	if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+		return "", nil
+	}
+	if m, ok := v.Interface().(encoding.TextMarshaler); ok {
+		b, err := m.MarshalText()
+		return string(b), err
+	}
+	switch reflect.Indirect(v).Kind() {
+	case reflect.Struct, reflect.Slice, reflect.Array, reflect.Map, reflect.Interface:
+		return toJSON(v.Interface())
+	}
+	return fmt.Sprint(reflect.Indirect(v).Interface()), nil
+}
+
+func toJSON(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+// Count describes n listed items out of total, ex. "20 of 4312 users". A
+// total of -1 means there are more than were listed.
+func Count(n int, total int, noun string) string {
+	switch {
+	case total < 0:
+		return fmt.Sprintf("%d %s (more available)", n, noun)
+	case total > n:
+		return fmt.Sprintf("%d of %d %s", n, total, noun)
+	}
+	return fmt.Sprintf("%d %s", n, noun)
+}
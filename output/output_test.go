@@ -0,0 +1,142 @@
+package output
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+type record struct {
+	Name    string            `json:"name"`
+	Count   int               `json:"count"`
+	Tags    []string          `json:"tags"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Updated *time.Time        `json:"updated"`
+	Secret  string            `json:"-"`
+	Plain   bool
+	hidden  string
+}
+
+func TestNewOptions(t *testing.T) {
+	tests := []struct {
+		format string
+		tmpl   string
+		want   string
+		err    bool
+	}{
+		{"", "", Table, false},
+		{"JSON", "", JSON, false},
+		{"yaml", "", YAML, false},
+		{"csv", "", CSV, false},
+		{"", "{{.}}", Template, false},
+		{"template", "{{.}}", Template, false},
+		{"template", "", "", true},
+		{"json", "{{.}}", "", true},
+		{"xml", "", "", true},
+	}
+	for _, tt := range tests {
+		opts, err := NewOptions(tt.format, tt.tmpl)
+		if (err != nil) != tt.err || (!tt.err && opts.Format != tt.want) {
+			t.Errorf("NewOptions(%q, %q) = %q, %v", tt.format, tt.tmpl, opts.Format, err)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	updated := time.Date(2026, 10, 18, 9, 30, 0, 0, time.UTC)
+	records := []record{
+		{Name: "landing", Count: 2, Tags: []string{"a", "b"}, Labels: map[string]string{"team": "portal"}, Updated: &updated, Secret: "s3cret", Plain: true, hidden: "x"},
+		{Name: "with, comma", Tags: nil},
+	}
+	tests := []struct {
+		format string
+		tmpl   string
+		v      interface{}
+		want   string
+	}{
+		{JSON, "", records[1:], `[
+  {
+    "name": "with, comma",
+    "count": 0,
+    "tags": null,
+    "updated": null,
+    "Plain": false
+  }
+]
+`},
+		{JSON, "", []record(nil), "[]\n"},
+		{YAML, "", records[:1], `- Plain: true
+  count: 2
+  labels:
+    team: portal
+  name: landing
+  tags:
+  - a
+  - b
+  updated: "2026-10-18T09:30:00Z"
+`},
+		{YAML, "", []record{}, "[]\n"},
+		{CSV, "", records, `name,count,tags,labels,updated,Plain
+landing,2,"[""a"",""b""]","{""team"":""portal""}",2026-10-18T09:30:00Z,true
+"with, comma",0,null,null,,false
+`},
+		{CSV, "", records[0], `name,count,tags,labels,updated,Plain
+landing,2,"[""a"",""b""]","{""team"":""portal""}",2026-10-18T09:30:00Z,true
+`},
+		{CSV, "", []record(nil), "name,count,tags,labels,updated,Plain\n"},
+		{Template, `{{range .}}{{.Name}}={{.Count}} {{json .Tags}}{{"\n"}}{{end}}`, records, "landing=2 [\"a\",\"b\"]\nwith, comma=0 null\n"},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		opts := Options{Format: tt.format, Template: tt.tmpl, Out: &out}
+		if err := Render(tt.v, opts, func() { t.Errorf("%s output printed the table", tt.format) }); err != nil {
+			t.Errorf("%s: %v", tt.format, err)
+			continue
+		}
+		if out.String() != tt.want {
+			t.Errorf("%s output =\n%s\nwant\n%s", tt.format, out.String(), tt.want)
+		}
+		if strings.Contains(out.String(), "s3cret") {
+			t.Errorf("%s output has a json:\"-\" field", tt.format)
+		}
+	}
+}
+
+func TestRenderErrors(t *testing.T) {
+	var out bytes.Buffer
+	if err := Render([]string{"a"}, Options{Format: CSV, Out: &out}, nil); err == nil {
+		t.Error("CSV of strings succeeded")
+	}
+	if err := Render(nil, Options{Format: Template, Template: "{{.Name", Out: &out}, nil); err == nil || !strings.Contains(err.Error(), "Invalid template") {
+		t.Errorf("bad template = %v", err)
+	}
+	if err := Render(record{Name: "landing"}, Options{Format: Template, Template: "{{.Missing}}", Out: &out}, nil); err == nil {
+		t.Error("template of a missing field succeeded")
+	}
+}
+
+func TestTable(t *testing.T) {
+	var printed bool
+	if err := Render([]record{}, Options{Format: Table}, func() { printed = true }); err != nil || !printed {
+		t.Errorf("table output = %v, printed %v", err, printed)
+	}
+}
+
+func TestCount(t *testing.T) {
+	tests := []struct {
+		n     int
+		total int
+		want  string
+	}{
+		{20, 4312, "20 of 4312 users"},
+		{20, 20, "20 users"},
+		{20, 0, "20 users"},
+		{20, -1, "20 users (more available)"},
+	}
+	for _, tt := range tests {
+		if got := Count(tt.n, tt.total, "users"); got != tt.want {
+			t.Errorf("Count(%d, %d) = %q, want %q", tt.n, tt.total, got, tt.want)
+		}
+	}
+}
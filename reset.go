@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ghchinoy/atmotool/output"
+	"github.com/ryanuber/columnize"
+	"golang.org/x/term"
+)
+
+// defaultResetProfile is the reset profile used without --profile
+const defaultResetProfile = "full"
+
+// resetProfiles are the built-in reset profiles, lists of CMS paths in which
+// {theme} stands for the theme reset. Profiles of the same name in the
+// configuration replace them.
+var resetProfiles = map[string][]string{
+	"full": {
+		CMLandingIndex,
+		"/resources/theme/{theme}" + CMInternationalization,
+		"/resources/theme/{theme}" + CMCustomLess,
+		"/resources/theme/{theme}" + CMFavicon,
+		"/resources/theme/{theme}/SOA",
+		"/resources/theme/{theme}/less",
+		"/resources/theme/{theme}/style",
+	},
+	"styles": {
+		"/resources/theme/{theme}" + CMCustomLess,
+		"/resources/theme/{theme}" + CMFavicon,
+		"/resources/theme/{theme}/SOA",
+		"/resources/theme/{theme}/less",
+		"/resources/theme/{theme}/style",
+	},
+	"landing": {
+		CMLandingIndex,
+	},
+}
+
+// resetResult is what happened to a CMS path in a reset
+type resetResult struct {
+	Path string `json:"path"`
+	// Status is deleted, absent (there was nothing to delete) or failed
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// resetTargets returns the CMS paths a reset profile deletes for a theme,
+// narrowed to those matching an only selector, if there are any, and not
+// matching an except selector
+func resetTargets(profile string, theme string, only []string, except []string) ([]string, error) {
+	if profile == "" {
+		profile = defaultResetProfile
+	}
+	templates, ok := config.ResetProfiles[profile]
+	if !ok {
+		templates, ok = resetProfiles[profile]
+	}
+	if !ok {
+		var names []string
+		for name := range resetProfiles {
+			names = append(names, name)
+		}
+		for name := range config.ResetProfiles {
+			if resetProfiles[name] == nil {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("No reset profile %q, use one of %s", profile, strings.Join(names, ", "))
+	}
+
+	var all []string
+	for _, t := range templates {
+		all = append(all, cleanCMSPath(strings.Replace(t, "{theme}", theme, -1)))
+	}
+	for _, sel := range only {
+		if !matchesAny(all, sel) {
+			return nil, fmt.Errorf("--only %s matches nothing in reset profile %s", sel, profile)
+		}
+	}
+
+	var paths []string
+	for _, p := range all {
+		if len(only) > 0 && !matchesAny([]string{p}, only...) {
+			continue
+		}
+		if matchesAny([]string{p}, except...) {
+			continue
+		}
+		paths = append(paths, p)
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("Nothing left to reset in reset profile %s", profile)
+	}
+	return paths, nil
+}
+
+// matchesAny reports whether a selector matches any of the paths. A selector
+// starting with / matches that CMS path and what's below it; otherwise it's
+// a name, or names separated by /, in the path, ex. less or home/landing.
+func matchesAny(paths []string, selectors ...string) bool {
+	for _, sel := range selectors {
+		absolute := strings.HasPrefix(sel, "/")
+		if absolute {
+			sel = cleanCMSPath(sel)
+		} else {
+			sel = "/" + strings.Trim(sel, "/") + "/"
+		}
+		for _, p := range paths {
+			if absolute && (p == sel || strings.HasPrefix(p, sel+"/")) {
+				return true
+			}
+			if !absolute && strings.Contains(p+"/", sel) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// splitList splits a comma separated list from the command line
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// confirmReset lists the paths a reset deletes and, unless yes or in a dry
+// run, asks whether to go ahead. Both are on stderr, leaving stdout to the
+// results, ex. with --output json.
+func confirmReset(paths []string, yes bool) error {
+	fmt.Fprintf(os.Stderr, "Resetting %s deletes:\n", config.URL)
+	for _, p := range paths {
+		fmt.Fprintln(os.Stderr, " ", p)
+	}
+	if yes || config.DryRun {
+		return nil
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return errors.New("Not resetting without confirmation, use --yes to reset without asking")
+	}
+	fmt.Fprint(os.Stderr, "Delete them? [y/N] ")
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+		return errors.New("Reset cancelled")
+	}
+	return nil
+}
+
+// backupBeforeReset backs up the paths a reset deletes to a timestamped
+// archive in the current directory, and prints how to restore it on stderr.
+// A dry run deletes nothing, so isn't backed up.
+func backupBeforeReset(theme string, paths []string) error {
+	if config.DryRun {
+		fmt.Fprintln(os.Stderr, "Not backing up, a dry run deletes nothing")
+		return nil
+	}
+	archive := fmt.Sprintf("atmotool-reset-%s-%s.zip", theme, time.Now().Format("20060102-150405"))
+	if err := cmsBackup(paths, archive); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "To undo the reset, atmotool restore %s\n", archive)
+	return nil
+}
+
+// resetCM deletes CMS paths, carrying on past failures, and outputs what
+// happened to each. The theme's styles are rebuilt if anything in it was
+// deleted.
+func resetCM(theme string, paths []string) error {
+	client, err := connect()
+	if err != nil {
+		return err
+	}
+
+	var results []resetResult
+	var failed int
+	rebuild := false
+	for _, p := range paths {
+		log.Println("Deleting", p)
+		r := resetResult{Path: p, Status: "deleted"}
+		existed, err := callDeleteURL(client, p)
+		switch {
+		case err != nil:
+			r.Status, r.Error = "failed", err.Error()
+			failed++
+		case config.DryRun:
+			r.Status = "dry run"
+		case !existed:
+			r.Status = "absent"
+		}
+		if existed && strings.HasPrefix(p, path.Join("/resources/theme", theme)+"/") {
+			rebuild = true
+		}
+		results = append(results, r)
+	}
+
+	err = output.Render(results, format, func() {
+		data := []string{"Path | Status"}
+		for _, r := range results {
+			status := r.Status
+			if r.Error != "" {
+				status += ": " + r.Error
+			}
+			data = append(data, fmt.Sprintf("%s | %s", r.Path, status))
+		}
+		fmt.Println(columnize.SimpleFormat(data))
+	})
+	if err != nil {
+		return err
+	}
+
+	if rebuild {
+		if err = rebuildStyles(config, theme); err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d paths couldn't be deleted", failed, len(paths))
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ghchinoy/atmotool/output"
+)
+
+func TestMatchesAny(t *testing.T) {
+	paths := []string{
+		"/content/home/landing/index.htm",
+		"/resources/theme/default/less",
+		"/resources/theme/default/style",
+	}
+	tests := []struct {
+		selectors []string
+		want      bool
+	}{
+		{nil, false},
+		{[]string{"less"}, true},
+		{[]string{"/less"}, false},
+		{[]string{"les"}, false},
+		{[]string{"home/landing"}, true},
+		{[]string{"landing/"}, true},
+		{[]string{"default"}, true},
+		{[]string{"index.htm"}, true},
+		{[]string{"/resources/theme/default"}, true},
+		{[]string{"/resources/theme/default/"}, true},
+		{[]string{"/resources/theme/def"}, false},
+		{[]string{"/resources/theme/default/less/custom.less"}, false},
+		{[]string{"fonts", "style"}, true},
+		{[]string{"fonts", "/content/home"}, true},
+	}
+	for _, tt := range tests {
+		if got := matchesAny(paths, tt.selectors...); got != tt.want {
+			t.Errorf("matchesAny(%v) = %v, want %v", tt.selectors, got, tt.want)
+		}
+	}
+}
+
+func TestResetTargets(t *testing.T) {
+	tests := []struct {
+		profile string
+		only    []string
+		except  []string
+		want    []string
+		err     bool
+	}{
+		{"landing", nil, nil, []string{CMLandingIndex}, false},
+		{"styles", []string{"less"}, nil, []string{"/resources/theme/blue" + CMCustomLess, "/resources/theme/blue/less"}, false},
+		{"styles", []string{"/resources/theme/blue"}, []string{"less", "style"}, []string{"/resources/theme/blue/SOA"}, false},
+		{"styles", []string{"/resources/theme/blue/less"}, []string{CMCustomLess[1:]}, []string{"/resources/theme/blue/less"}, false},
+		{"styles", []string{"fonts"}, nil, nil, true},
+		{"landing", nil, []string{"landing"}, nil, true},
+		{"nope", nil, nil, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := resetTargets(tt.profile, "blue", tt.only, tt.except)
+		if (err != nil) != tt.err || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("resetTargets(%s, only %v, except %v) = %v, %v", tt.profile, tt.only, tt.except, got, err)
+		}
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	tests := []struct {
+		list string
+		want []string
+	}{
+		{"", nil},
+		{"less", []string{"less"}},
+		{" less, style ,,SOA ", []string{"less", "style", "SOA"}},
+	}
+	for _, tt := range tests {
+		if got := splitList(tt.list); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitList(%q) = %v, want %v", tt.list, got, tt.want)
+		}
+	}
+}
+
+func TestBackupBeforeResetDryRun(t *testing.T) {
+	s := newTestCM(t)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	s.PutFile(CMLandingIndex, []byte("<h1>hi</h1>"))
+
+	config.DryRun = true
+	if err = backupBeforeReset("default", []string{CMLandingIndex}); err != nil {
+		t.Fatal(err)
+	}
+	if archives, _ := filepath.Glob(filepath.Join(dir, "*.zip")); len(archives) != 0 || len(s.Requests()) != 0 {
+		t.Errorf("dry run backed up to %v with requests %v", archives, s.Requests())
+	}
+
+	config.DryRun = false
+	if err = backupBeforeReset("default", []string{CMLandingIndex}); err != nil {
+		t.Fatal(err)
+	}
+	if archives, _ := filepath.Glob(filepath.Join(dir, "atmotool-reset-default-*.zip")); len(archives) != 1 {
+		t.Errorf("backed up to %v, want one archive", archives)
+	}
+}
+
+// captureOutput returns what f writes to standard output and standard error
+func captureOutput(t *testing.T, f func()) (string, string) {
+	t.Helper()
+	capture := func(file **os.File) (func() string, error) {
+		r, w, err := os.Pipe()
+		if err != nil {
+			return nil, err
+		}
+		orig := *file
+		*file = w
+		out := make(chan string)
+		go func() {
+			var buf bytes.Buffer
+			io.Copy(&buf, r)
+			out <- buf.String()
+		}()
+		return func() string {
+			*file = orig
+			w.Close()
+			return <-out
+		}, nil
+	}
+	stdout, err := capture(&os.Stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr, err := capture(&os.Stderr)
+	if err != nil {
+		stdout()
+		t.Fatal(err)
+	}
+	f()
+	return stdout(), stderr()
+}
+
+// TestResetOutputJSON keeps the confirmation and backup messages of a reset
+// off stdout, so it's only the JSON results
+func TestResetOutputJSON(t *testing.T) {
+	s := newTestCM(t)
+	s.PutFile(CMLandingIndex, []byte("<h1>hi</h1>"))
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	paths := []string{CMLandingIndex}
+	stdout, stderr := captureOutput(t, func() {
+		format, _ = output.NewOptions("json", "")
+		if err = confirmReset(paths, true); err == nil {
+			err = backupBeforeReset("default", paths)
+		}
+		if err == nil {
+			err = resetCM("default", paths)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results []resetResult
+	if err := json.Unmarshal([]byte(stdout), &results); err != nil {
+		t.Fatalf("stdout isn't JSON: %v\n%s", err, stdout)
+	}
+	if len(results) != 1 || results[0].Path != CMLandingIndex || results[0].Status != "deleted" {
+		t.Errorf("results = %+v", results)
+	}
+	for _, want := range []string{"Resetting " + s.URL + " deletes:", "  " + CMLandingIndex, "To undo the reset, atmotool restore atmotool-reset-default-"} {
+		if !strings.Contains(stderr, want) {
+			t.Errorf("stderr = %q, want %q in it", stderr, want)
+		}
+	}
+}
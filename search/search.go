@@ -0,0 +1,186 @@
+package search
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/ghchinoy/atmotool/cm"
+	"github.com/ghchinoy/atmotool/control"
+	"github.com/ghchinoy/atmotool/output"
+	"github.com/ryanuber/columnize"
+)
+
+const (
+	// CMSearchURI is the CM search endpoint
+	CMSearchURI = "/api/search"
+	// sortOrderPrefix is the prefix of CM's sort orders, ex. com.soa.sort.order.alphabetical
+	sortOrderPrefix = "com.soa.sort.order."
+)
+
+// Types are the entity types a search can be limited to
+var Types = []string{"api", "app", "user", "group", "board"}
+
+// Options narrow and order a search
+type Options struct {
+	// Type limits results to one of Types
+	Type string
+	// SortBy is a CM sort order, either in full or without the com.soa.sort.order.
+	// prefix, ex. alphabetical, title_sort or rating
+	SortBy string
+	// Federation includes results from federated tenants
+	Federation bool
+}
+
+// Result is a search result, summarizing a cm.Item
+type Result struct {
+	Type          string  `json:"type"`
+	Title         string  `json:"title"`
+	ID            string  `json:"id"`
+	Visibility    string  `json:"visibility"`
+	Categories    string  `json:"categories"`
+	Description   string  `json:"description"`
+	Updated       string  `json:"updated"`
+	Followers     int     `json:"followers"`
+	Connections   int     `json:"connections"`
+	Rating        float32 `json:"rating"`
+	ApisCount     int     `json:"apisCount"`
+	AppsCount     int     `json:"appsCount"`
+	PostsCount    int     `json:"postsCount"`
+	CommentsCount int     `json:"commentsCount"`
+	GroupsCount   int     `json:"groupsCount"`
+}
+
+// Search outputs the results of a CM search
+func Search(query string, opts Options, config control.Configuration, format output.Options, debug bool) error {
+	items, total, err := Find(query, opts, config, debug)
+	if err != nil {
+		return err
+	}
+	var results []Result
+	for _, v := range items {
+		results = append(results, newResult(v, opts.Type))
+	}
+	return output.Render(results, format, func() {
+		fmt.Println(output.Count(len(results), total, "results"))
+		if len(results) == 0 {
+			return
+		}
+		var data []string
+		data = append(data, "Type | Title | Visibility | Categories | Counts | ID")
+		for _, v := range results {
+			data = append(data, fmt.Sprintf("%s | %s | %s | %s | %s | %s", v.Type, v.Title, v.Visibility, v.Categories, v.counts(), v.ID))
+		}
+		fmt.Println(columnize.SimpleFormat(data))
+	})
+}
+
+// Find returns the items matching a search, up to the configured limit, and the total CM has
+func Find(query string, opts Options, config control.Configuration, debug bool) ([]cm.Item, int, error) {
+	uri, err := searchURI(query, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	if debug {
+		log.Println("Searching", uri)
+	}
+	client, err := control.NewClient(config, debug)
+	if err != nil {
+		return nil, 0, err
+	}
+	return client.GetAll(uri)
+}
+
+// searchURI builds the /api/search path for a query
+func searchURI(query string, opts Options) (string, error) {
+	q := strings.TrimSpace(query)
+	if opts.Type != "" {
+		if !validType(opts.Type) {
+			return "", fmt.Errorf("Unknown type %q, use one of %s", opts.Type, strings.Join(Types, ", "))
+		}
+		q = strings.TrimSpace(q + " type:" + opts.Type)
+	}
+	if q == "" {
+		return "", errors.New("Please provide something to search for")
+	}
+
+	params := url.Values{}
+	params.Set("q", q)
+	if opts.SortBy != "" {
+		sortBy := opts.SortBy
+		if !strings.HasPrefix(sortBy, sortOrderPrefix) {
+			sortBy = sortOrderPrefix + sortBy
+		}
+		params.Set("sortBy", sortBy)
+	}
+	params.Set("Federation", fmt.Sprint(opts.Federation))
+	return CMSearchURI + "?" + params.Encode(), nil
+}
+
+func validType(t string) bool {
+	for _, v := range Types {
+		if v == t {
+			return true
+		}
+	}
+	return false
+}
+
+// newResult summarizes an Item; searchType is used when CM doesn't categorize the type
+func newResult(v cm.Item, searchType string) Result {
+	r := Result{
+		Type:          searchType,
+		Title:         v.Title,
+		ID:            v.Guid.Value,
+		Description:   v.Description,
+		Updated:       v.PubDate,
+		Followers:     v.Followers,
+		Connections:   v.Connections,
+		Rating:        v.Rating,
+		ApisCount:     v.ApisCount,
+		AppsCount:     v.AppsCount,
+		PostsCount:    v.PostsCount,
+		CommentsCount: v.CommentsCount,
+		GroupsCount:   v.GroupsCount,
+	}
+	var categories []string
+	for _, c := range v.Category {
+		switch {
+		case c.Domain == "uddi:soa.com:visibility":
+			r.Visibility = c.Value
+		case strings.HasSuffix(c.Domain, ":resourcetype"):
+			r.Type = c.Value
+		default:
+			categories = append(categories, c.Value)
+		}
+	}
+	// Shorten Registered Users visibility
+	if r.Visibility == "com.soa.visibility.registered.users" {
+		r.Visibility = "Registered"
+	}
+	r.Categories = strings.Join(categories, ", ")
+	return r
+}
+
+// counts lists the non-zero counts of a Result, for the table
+func (r Result) counts() string {
+	var counts []string
+	add := func(name string, n int) {
+		if n > 0 {
+			counts = append(counts, fmt.Sprintf("%d %s", n, name))
+		}
+	}
+	add("followers", r.Followers)
+	add("connections", r.Connections)
+	add("apis", r.ApisCount)
+	add("apps", r.AppsCount)
+	add("posts", r.PostsCount)
+	add("comments", r.CommentsCount)
+	add("groups", r.GroupsCount)
+	if r.Rating > 0 {
+		counts = append(counts, fmt.Sprintf("rated %.1f", r.Rating))
+	}
+	return strings.Join(counts, ", ")
+}
@@ -0,0 +1,123 @@
+package search
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ghchinoy/atmotool/cmtest"
+	"github.com/ghchinoy/atmotool/output"
+)
+
+// newServer starts a fake CM with a temporary home for the login cache
+func newServer(t *testing.T) *cmtest.Server {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	s := cmtest.NewServer()
+	t.Cleanup(s.Close)
+	return s
+}
+
+// searches lists the search requests the Server received
+func searches(s *cmtest.Server) []string {
+	var got []string
+	for _, r := range s.Requests() {
+		if strings.HasPrefix(r, "GET "+CMSearchURI) {
+			got = append(got, r)
+		}
+	}
+	return got
+}
+
+func TestSearchURI(t *testing.T) {
+	tests := []struct {
+		query string
+		opts  Options
+		want  string
+		err   string
+	}{
+		{"weather", Options{}, "/api/search?Federation=false&q=weather", ""},
+		{" weather ", Options{Type: "api", Federation: true}, "/api/search?Federation=true&q=weather+type%3Aapi", ""},
+		{"", Options{Type: "user"}, "/api/search?Federation=false&q=type%3Auser", ""},
+		{"weather", Options{SortBy: "alphabetical"}, "/api/search?Federation=false&q=weather&sortBy=com.soa.sort.order.alphabetical", ""},
+		{"weather", Options{SortBy: "com.soa.sort.order.rating"}, "/api/search?Federation=false&q=weather&sortBy=com.soa.sort.order.rating", ""},
+		{"weather", Options{Type: "policy"}, "", `Unknown type "policy"`},
+		{"  ", Options{}, "", "Please provide something to search for"},
+	}
+	for _, tt := range tests {
+		got, err := searchURI(tt.query, tt.opts)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("searchURI(%q, %+v) = %q, %v, want an error with %q", tt.query, tt.opts, got, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("searchURI(%q, %+v) = %q, %v, want %q", tt.query, tt.opts, got, err, tt.want)
+		}
+	}
+}
+
+func TestFindPages(t *testing.T) {
+	s := newServer(t)
+	for i := 0; i < 45; i++ {
+		s.AddUser(fmt.Sprintf("Weather Watcher %d", i), fmt.Sprintf("watcher%d@example.com", i))
+	}
+	s.AddUser("Someone Else", "else@example.com")
+	s.AddApp("Weather Station", "")
+
+	config := s.Config()
+	config.PageSize = 20
+	items, total, err := Find("weather", Options{Type: "user"}, config, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 45 || total != 45 {
+		t.Errorf("found %d of %d users, want 45 of 45", len(items), total)
+	}
+	seen := map[string]bool{}
+	for _, v := range items {
+		seen[v.Guid.Value] = true
+	}
+	if len(seen) != 45 {
+		t.Errorf("found %d distinct users, want 45", len(seen))
+	}
+	if got := searches(s); len(got) != 3 || !strings.Contains(got[2], "start=40") {
+		t.Errorf("searches = %v, want 3 pages of 20", got)
+	}
+
+	config.Limit = 25
+	items, total, err = Find("weather", Options{Type: "user"}, config, false)
+	if err != nil || len(items) != 25 || total != 45 {
+		t.Errorf("--limit 25 found %d of %d, %v", len(items), total, err)
+	}
+}
+
+func TestSearchJSON(t *testing.T) {
+	s := newServer(t)
+	app := s.AddApp("Weather Station", "com.soa.visibility.registered.users")
+	s.AddAPI("Weather", "", "", "")
+	s.AddUser("News Reader", "reader@example.com")
+
+	var out bytes.Buffer
+	format := output.Options{Format: output.JSON, Out: &out}
+	if err := Search("weather", Options{Type: "app"}, s.Config(), format, false); err != nil {
+		t.Fatal(err)
+	}
+	var results []Result
+	if err := json.Unmarshal(out.Bytes(), &results); err != nil {
+		t.Fatalf("%v: %s", err, out.String())
+	}
+	if len(results) != 1 || results[0].ID != app || results[0].Title != "Weather Station" || results[0].Type != "app" || results[0].Visibility != "Registered" {
+		t.Errorf("results = %+v", results)
+	}
+
+	if err := Search("", Options{}, s.Config(), format, false); err == nil {
+		t.Error("an empty search succeeded")
+	}
+	if got := searches(s); len(got) != 1 {
+		t.Errorf("searches = %v, want only the valid one", got)
+	}
+}
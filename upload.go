@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ghchinoy/atmotool/zip"
+)
+
+// uploadTarget is a local file and the CMS folder it's uploaded to
+type uploadTarget struct {
+	local  string
+	folder string
+}
+
+// uploadTargets expands the arguments of upload file, each a local file or
+// glob optionally followed by :cmspath, into the files to upload and their
+// CMS folders. Arguments without a CMS path go to folder.
+func uploadTargets(args []string, folder string) ([]uploadTarget, error) {
+	var targets []uploadTarget
+	for _, arg := range args {
+		local, cmsPath := arg, folder
+		// a CMS path is absolute, which tells it from a Windows drive letter
+		if i := strings.LastIndex(arg, ":"); i > 1 && strings.HasPrefix(arg[i+1:], "/") {
+			local, cmsPath = arg[:i], arg[i+1:]
+		}
+		if cmsPath == "" {
+			return nil, fmt.Errorf("No CMS path for %s, use --path or %s:<cmspath>", local, local)
+		}
+		cmsPath = cleanCMSPath(cmsPath)
+
+		if !strings.ContainsAny(local, "*?[") {
+			info, err := os.Stat(local)
+			if err != nil {
+				return nil, err
+			}
+			if info.IsDir() {
+				return nil, fmt.Errorf("%s is a directory, see upload dir", local)
+			}
+			targets = append(targets, uploadTarget{local: local, folder: cmsPath})
+			continue
+		}
+		// globbed here, so they work the same in any shell, and quoted
+		matches, err := filepath.Glob(local)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid pattern %s: %s", local, err)
+		}
+		var n int
+		for _, m := range matches {
+			if info, err := os.Stat(m); err == nil && !info.IsDir() {
+				targets = append(targets, uploadTarget{local: m, folder: cmsPath})
+				n++
+			}
+		}
+		if n == 0 {
+			return nil, fmt.Errorf("No files match %s", local)
+		}
+	}
+	return targets, nil
+}
+
+// unpackQuery returns the query for uploading a local file: zips are unpacked
+// into the CMS folder and other files aren't, unless unpack says otherwise
+func unpackQuery(local string, unpack *bool) string {
+	u := strings.HasSuffix(strings.ToLower(local), ".zip")
+	if unpack != nil {
+		u = *unpack
+	}
+	if u {
+		return "?unpack=true"
+	}
+	return "?unpack=false"
+}
+
+// upload uploads local files, named by the arguments of upload file, to CMS
+// folders. It stops at the first failure, unless keepGoing, when the rest are
+// uploaded and the failures listed at the end.
+func upload(args []string, folder string, unpack *bool, keepGoing bool) error {
+	targets, err := uploadTargets(args, folder)
+	if err != nil {
+		return err
+	}
+	client, err := connect()
+	if err != nil {
+		return err
+	}
+
+	var failed []string
+	for _, t := range targets {
+		log.Printf("Uploading %s to %s", t.local, t.folder)
+		err = uploadFile(client, t.local, cmsURI(t.folder)+unpackQuery(t.local, unpack))
+		if err != nil && !keepGoing {
+			return fmt.Errorf("Unable to upload %s: %w", t.local, err)
+		}
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s to %s: %s", t.local, path.Join(t.folder, filepath.Base(t.local)), err))
+		}
+	}
+
+	if len(failed) > 0 {
+		fmt.Println("Failed uploads:")
+		for _, f := range failed {
+			fmt.Println(" ", f)
+		}
+		return fmt.Errorf("%d of %d uploads failed", len(failed), len(targets))
+	}
+	if !config.DryRun {
+		fmt.Printf("Uploaded %d files\n", len(targets))
+	}
+	return nil
+}
+
+// uploadDir uploads the files of a local directory below a CMS folder, as
+// one zip made by zip.ZipFolder and unpacked by CM, then lists the folder to
+// check they're all there. Files zip leaves out, such as empty files, aren't
+// uploaded.
+func uploadDir(localDir string, folder string) error {
+	info, err := os.Stat(localDir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory, see upload file", localDir)
+	}
+	folder = cleanCMSPath(folder)
+	if isCMSRoot(folder) && folder != "/content" && folder != "/resources" {
+		return fmt.Errorf("%s is not below /content or /resources", folder)
+	}
+	localDir = filepath.Clean(localDir)
+	names, err := zippedNames(localDir)
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("No files to upload in %s", localDir)
+	}
+
+	client, err := connect()
+	if err != nil {
+		return err
+	}
+	staging, cleanup, err := stagingDir()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	archive := filepath.Join(staging, filepath.Base(localDir)+".zip")
+	if err = zip.ZipFolder(localDir, archive); err != nil {
+		return err
+	}
+	log.Printf("Uploading %d files to %s", len(names), folder)
+	if err = uploadFile(client, archive, cmsURI(folder)+"?unpack=true"); err != nil {
+		return err
+	}
+	if config.DryRun {
+		return nil
+	}
+
+	remote, err := walkCMS(folder)
+	if err != nil {
+		return fmt.Errorf("Uploaded %s, but unable to list %s to check it: %w", localDir, folder, err)
+	}
+	uploaded := make(map[string]bool)
+	for _, e := range remote {
+		uploaded[e.Path] = !e.Folder
+	}
+	var missing []string
+	for _, name := range names {
+		if !uploaded[name] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("Uploaded %s, but %d of %d files aren't in %s: %s", localDir, len(missing), len(names), folder, strings.Join(missing, ", "))
+	}
+	fmt.Printf("Uploaded %d files from %s to %s\n", len(names), localDir, folder)
+	return nil
+}
+
+// zippedNames returns the / separated paths, relative to dir, of the files
+// zip.ZipFolder puts in a zip of dir, sorted
+func zippedNames(dir string) ([]string, error) {
+	var names []string
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() || info.Size() == 0 || zip.Excluded(info.Name()) {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(rel))
+		return nil
+	})
+	sort.Strings(names)
+	return names, err
+}
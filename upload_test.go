@@ -0,0 +1,125 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUploadDir(t *testing.T) {
+	s := newTestCM(t)
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"index.htm":         "<h1>hi</h1>",
+		"img/banner.png":    "\x89PNG",
+		".well-known/x.txt": "x",
+		"empty.txt":         "",
+		".DS_Store":         "junk",
+		"local.conf":        "{}",
+	})
+
+	if err := uploadDir(dir, "/content/home/landing"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"/content/home/landing/.well-known/x.txt",
+		"/content/home/landing/img/banner.png",
+		"/content/home/landing/index.htm",
+	}
+	got := s.Files("/content/home/landing")
+	if len(got) != len(want) {
+		t.Fatalf("CMS files = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("CMS files = %v, want %v", got, want)
+			break
+		}
+	}
+	if n := uploads(s); n != 1 {
+		t.Errorf("upload dir made %d uploads, want 1", n)
+	}
+}
+
+func TestUploadDirFault(t *testing.T) {
+	s := newTestCM(t)
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"index.htm": "<h1>hi</h1>"})
+	s.FailPath("/content/home/landing", 500)
+
+	if err := uploadDir(dir, "/content/home/landing"); err == nil {
+		t.Error("upload dir to a folder CM faults on succeeded")
+	}
+}
+
+func TestUploadTargets(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"style/a.css":   "a {}",
+		"style/b.css":   "b {}",
+		"style/c.less":  "c {}",
+		"img/logo.png":  "\x89PNG",
+		"img/sub/x.png": "\x89PNG",
+	})
+	in := func(name string) string { return filepath.Join(dir, filepath.FromSlash(name)) }
+
+	tests := []struct {
+		name   string
+		args   []string
+		folder string
+		want   []uploadTarget
+		err    string
+	}{
+		{"file to --path", []string{in("style/a.css")}, "/resources/theme/default/style/", []uploadTarget{{in("style/a.css"), "/resources/theme/default/style"}}, ""},
+		{"own CMS paths", []string{in("style/a.css") + ":/resources/theme/default/style", in("img/logo.png")}, "/content/img", []uploadTarget{
+			{in("style/a.css"), "/resources/theme/default/style"},
+			{in("img/logo.png"), "/content/img"},
+		}, ""},
+		{"glob", []string{in("style/*.css") + ":/resources/theme/default/style"}, "", []uploadTarget{
+			{in("style/a.css"), "/resources/theme/default/style"},
+			{in("style/b.css"), "/resources/theme/default/style"},
+		}, ""},
+		{"glob skips folders", []string{in("img/*")}, "/content/img", []uploadTarget{{in("img/logo.png"), "/content/img"}}, ""},
+		{"no CMS path", []string{in("style/a.css")}, "", nil, "No CMS path for"},
+		{"drive letter isn't a CMS path", []string{"C:/style/a.css"}, "", nil, "No CMS path for C:/style/a.css"},
+		{"relative CMS path", []string{in("style/a.css") + ":style"}, "", nil, "No CMS path for"},
+		{"directory", []string{in("style")}, "/content", nil, "is a directory, see upload dir"},
+		{"missing file", []string{in("style/missing.css")}, "/content", nil, "no such file"},
+		{"glob matching nothing", []string{in("style/*.js")}, "/content", nil, "No files match"},
+		{"bad glob", []string{in("style/[.css")}, "/content", nil, "Invalid pattern"},
+	}
+	for _, tt := range tests {
+		got, err := uploadTargets(tt.args, tt.folder)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: %v, want an error with %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %+v, %v, want %+v", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestUnpackQuery(t *testing.T) {
+	yes, no := true, false
+	tests := []struct {
+		local  string
+		unpack *bool
+		want   string
+	}{
+		{"site.zip", nil, "?unpack=true"},
+		{"SITE.ZIP", nil, "?unpack=true"},
+		{"site.css", nil, "?unpack=false"},
+		{"site.zip", &no, "?unpack=false"},
+		{"site.css", &yes, "?unpack=true"},
+		{"zip", nil, "?unpack=false"},
+	}
+	for _, tt := range tests {
+		if got := unpackQuery(tt.local, tt.unpack); got != tt.want {
+			t.Errorf("unpackQuery(%s, %v) = %s, want %s", tt.local, tt.unpack, got, tt.want)
+		}
+	}
+}
@@ -1,11 +1,8 @@
 package users
 
 import (
-	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"net/http"
 
 	"github.com/ghchinoy/atmotool/control"
 )
@@ -22,41 +19,25 @@ func DeleteUserList(users []string, config control.Configuration, debug bool) er
 		log.Printf("Deleting %v users...", len(users))
 	}
 
-	client, _, err := control.LoginToCM(config, debug)
+	client, err := control.NewClient(config, debug)
 	if err != nil {
-		log.Fatalln(err)
 		return err
 	}
 
-	url := config.URL + DeleteUserURI
-
 	for _, v := range users {
-		uri := fmt.Sprintf(url, v)
+		uri := fmt.Sprintf(DeleteUserURI, v)
 		if debug {
 			log.Println("DELETE", uri)
 		}
-		req, err := http.NewRequest("DELETE", uri, nil)
-		if err != nil {
-			return err
-		}
-		req = control.AddCsrfHeader(req, client)
-		resp, err := client.Do(req)
+		var bodyBytes []byte
+		err = client.Delete(uri, &bodyBytes)
 		if err != nil {
-			return err
-		}
-		defer resp.Body.Close()
-
-		if debug {
-			log.Println(resp.Status)
+			return fmt.Errorf("Unable to delete user %s: %w", v, err)
 		}
-
-		if resp.StatusCode == 200 {
-			bodyBytes, _ := ioutil.ReadAll(resp.Body)
-			fmt.Printf("User %s deleted (%s).\n", bodyBytes, v)
-		} else {
-			return errors.New("Unable to delete user " + v + " (" + resp.Status + ")")
+		if config.DryRun {
+			continue
 		}
-
+		fmt.Printf("User %s deleted (%s).\n", bodyBytes, v)
 	}
 
 	return nil
@@ -1,7 +1,7 @@
 package version
 
 const (
-	version     = "1.7.6"
+	version     = "1.8.0"
 	versionName = "cirrus"
 )
 
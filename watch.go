@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// watchInterval is how often a watched directory is checked for changes
+	watchInterval = 500 * time.Millisecond
+	// watchQuiet is how long a watched directory has to go without changes
+	// before they're uploaded, so a save touching several files, or an editor
+	// writing a file in steps, is uploaded once
+	watchQuiet = time.Second
+	// watchRetry is how long failed changes wait to be deployed again, unless
+	// more changes come first
+	watchRetry = 10 * time.Second
+)
+
+// watchTheme watches a local theme directory, laid out as the theme is in the
+// CMS, until interrupted. Changed files are uploaded to the theme, and when
+// any are below less/ its styles are rebuilt. Files deleted locally are left
+// in the CMS.
+func watchTheme(dir string, theme string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	if theme == "" {
+		theme = "default"
+	}
+	cmsPath := "/resources/theme/" + theme
+
+	if _, err = connect(); err != nil {
+		return err
+	}
+	last, _, err := walkLocal(dir)
+	if err != nil {
+		return err
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt)
+	defer signal.Stop(stop)
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+
+	fmt.Printf("Watching %s for changes to theme %s, Ctrl-C to stop\n", dir, theme)
+	pending := make(map[string]bool)
+	var changedAt, retryAt time.Time
+	for {
+		select {
+		case <-stop:
+			if len(pending) > 0 {
+				fmt.Printf("Stopped with %d changes not uploaded\n", len(pending))
+			}
+			return nil
+		case <-ticker.C:
+		}
+
+		files, _, err := walkLocal(dir)
+		if err != nil {
+			// ex. an editor's temporary file going away mid walk
+			if debug {
+				log.Println("Unable to check for changes:", err)
+			}
+			continue
+		}
+		for name, f := range files {
+			if prev, ok := last[name]; !ok || !prev.ModTime().Equal(f.ModTime()) || prev.Size() != f.Size() {
+				pending[name] = true
+				changedAt = time.Now()
+				retryAt = time.Time{}
+			}
+		}
+		for name := range last {
+			if files[name] == nil {
+				delete(pending, name)
+				log.Printf("%s was deleted, it's left in the CMS", name)
+			}
+		}
+		last = files
+
+		if len(pending) == 0 || time.Since(changedAt) < watchQuiet || time.Now().Before(retryAt) {
+			continue
+		}
+		var names []string
+		for name := range pending {
+			names = append(names, name)
+		}
+		pending = make(map[string]bool)
+		if failed := deployChanges(dir, cmsPath, theme, names, files); len(failed) > 0 {
+			for _, name := range failed {
+				pending[name] = true
+			}
+			retryAt = time.Now().Add(watchRetry)
+			log.Printf("Trying %d changes again in %s", len(failed), watchRetry)
+		}
+	}
+}
+
+// deployChanges uploads changed files of a watched theme directory, then
+// rebuilds the theme's styles if a less file changed. Failures are printed
+// rather than returned, so watching carries on, and the names that need
+// deploying again are returned: all of them when the upload fails, or the
+// less files when the styles don't build.
+func deployChanges(dir string, cmsPath string, theme string, names []string, files map[string]os.FileInfo) []string {
+	sort.Strings(names)
+	var less []string
+	for _, name := range names {
+		log.Println("Changed", name)
+		if strings.HasPrefix(name, "less/") {
+			less = append(less, name)
+		}
+	}
+
+	client, err := connect()
+	if err == nil {
+		err = uploadBatch(client, dir, cmsPath, names, files)
+	}
+	if err != nil {
+		log.Println("Upload failed:", err)
+		return names
+	}
+	if len(less) > 0 {
+		if err = rebuildStyles(config, theme); err != nil {
+			log.Println("Styles failed to build:", err)
+			return less
+		}
+	}
+	log.Printf("Deployed %d changes", len(names))
+	return nil
+}
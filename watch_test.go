@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestDeployChangesReturnsFailures(t *testing.T) {
+	s := newTestCM(t)
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"less/custom.less": "a { color: red; }",
+		"style/site.css":   "body {}",
+	})
+	files, _, err := walkLocal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{"style/site.css", "less/custom.less"}
+	cmsPath := "/resources/theme/default"
+
+	s.FailPathTimes(cmsPath, http.StatusServiceUnavailable, 1, "")
+	failed := deployChanges(dir, cmsPath, "default", names, files)
+	if want := []string{"less/custom.less", "style/site.css"}; !reflect.DeepEqual(failed, want) {
+		t.Errorf("failed upload returned %v, want %v", failed, want)
+	}
+	if len(s.Rebuilds()) != 0 {
+		t.Errorf("rebuilt %v after a failed upload", s.Rebuilds())
+	}
+
+	if failed := deployChanges(dir, cmsPath, "default", failed, files); len(failed) != 0 {
+		t.Errorf("deploying again returned %v", failed)
+	}
+	if _, ok := s.File(cmsPath + "/style/site.css"); !ok {
+		t.Error("style/site.css wasn't uploaded")
+	}
+	if got := s.Rebuilds(); !reflect.DeepEqual(got, []string{"default"}) {
+		t.Errorf("rebuilds = %v", got)
+	}
+
+	// only the less files are deployed again when the styles don't build
+	writeFiles(t, dir, map[string]string{"less/custom.less": "a {\n"})
+	files, _, _ = walkLocal(dir)
+	failed = deployChanges(dir, cmsPath, "default", names, files)
+	if want := []string{"less/custom.less"}; !reflect.DeepEqual(failed, want) {
+		t.Errorf("failed rebuild returned %v, want %v", failed, want)
+	}
+}
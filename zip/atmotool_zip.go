@@ -45,6 +45,12 @@ func contains(slice []string, item string) bool {
 	*/
 }
 
+// Excluded reports whether ZipFolder leaves out a file of the given name,
+// ex. .DS_Store or another zip
+func Excluded(name string) bool {
+	return contains(exclusions, name)
+}
+
 func copyContents(r io.Reader, w io.Writer) error {
 	var size int64
 	b := make([]byte, chunkSize)